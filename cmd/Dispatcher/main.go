@@ -3,10 +3,20 @@ package main
 import (
 	"Dispatcher/internal/config"
 	"Dispatcher/internal/entrypoint"
+	"Dispatcher/internal/logger"
+	storage "Dispatcher/internal/storage/postgres"
 	"fmt"
+	"log/slog"
+	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		// drop the subcommand so config flags after it are still parsed
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		os.Exit(migrate())
+	}
+
 	cfg := config.MustLoad()
 
 	fmt.Println(cfg)
@@ -14,10 +24,27 @@ func main() {
 	ep, err := entrypoint.New(cfg)
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	err = ep.Run()
-	if err != nil {
+	// Run returns only after shutdown, a non-nil error means something was
+	// not drained or closed cleanly.
+	if err := ep.Run(); err != nil {
 		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func migrate() int {
+	cfg := config.MustLoad()
+	log := logger.SetupLogger(cfg.Env)
+
+	applied, err := storage.Migrate(cfg, log)
+	if err != nil {
+		log.Error("migration failed", slog.Any("error", err))
+		return 1
 	}
+
+	log.Info("migrations finished", slog.Int("applied", applied))
+	return 0
 }
@@ -0,0 +1,97 @@
+// Package admission keeps single sources from taking over the dispatcher:
+// it limits how many tests a source may have buffered, how fast it may
+// submit them and how many tests the dispatcher holds at once.
+package admission
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Controller implements test.AdmissionControl with the limits of the source
+// table and one token bucket per source.
+type Controller struct {
+	table      *sources.Table
+	retryAfter time.Duration
+	// maxInFlight is config.Admission.MaxInFlight, 0 means no limit.
+	maxInFlight int
+
+	mu      sync.Mutex
+	buckets map[uint]*bucket
+	now     func() time.Time
+}
+
+func New(cfg config.Admission, table *sources.Table) *Controller {
+	return &Controller{
+		table:       table,
+		retryAfter:  cfg.RetryAfter,
+		maxInFlight: cfg.MaxInFlight,
+		buckets:     make(map[uint]*bucket),
+		now:         time.Now,
+	}
+}
+
+// Allow checks the in-flight and buffered limits first, so that a source
+// refused by them does not use up its tokens.
+func (c *Controller) Allow(sourceID uint, buffered, inFlight int) error {
+	if c.maxInFlight > 0 && inFlight >= c.maxInFlight {
+		return &test.QuotaError{
+			Reason:     fmt.Sprintf("%d tests in flight, the limit is %d", inFlight, c.maxInFlight),
+			RetryAfter: c.retryAfter,
+		}
+	}
+
+	s := c.table.Get(sourceID)
+	if s.MaxBuffered > 0 && buffered >= s.MaxBuffered {
+		return &test.QuotaError{
+			SourceID:   sourceID,
+			Reason:     fmt.Sprintf("%d tests buffered, the limit is %d", buffered, s.MaxBuffered),
+			RetryAfter: c.retryAfter,
+		}
+	}
+	if s.Rate == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.buckets[sourceID]
+	if !ok {
+		b = &bucket{tokens: float64(s.Burst), last: c.now()}
+		c.buckets[sourceID] = b
+	}
+	if wait := b.take(c.now(), s.Rate, s.Burst); wait > 0 {
+		return &test.QuotaError{
+			SourceID:   sourceID,
+			Reason:     fmt.Sprintf("rate limit of %v tests per second exceeded", s.Rate),
+			RetryAfter: wait,
+		}
+	}
+	return nil
+}
+
+// bucket is a token bucket refilled at rate tokens per second up to burst.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes a token and returns 0, or returns how long it takes until
+// there is one.
+func (b *bucket) take(now time.Time, rate float64, burst int) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(burst), b.tokens+elapsed.Seconds()*rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return max(time.Nanosecond, time.Duration((1-b.tokens)/rate*float64(time.Second)))
+}
@@ -0,0 +1,109 @@
+package admission
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newController(t *testing.T, cfg config.Admission, list ...config.Source) (*Controller, *time.Time) {
+	t.Helper()
+
+	table, err := sources.New(list, config.UnknownSources{})
+	if err != nil {
+		t.Fatalf("sources.New: %v", err)
+	}
+	c := New(cfg, table)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func quota(t *testing.T, err error) *test.QuotaError {
+	t.Helper()
+
+	var quotaErr *test.QuotaError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("error = %v, want a *test.QuotaError", err)
+	}
+	return quotaErr
+}
+
+func TestRateLimit(t *testing.T) {
+	c, now := newController(t, config.Admission{RetryAfter: time.Second}, config.Source{ID: 1, Rate: 2, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if err := c.Allow(1, 0, 0); err != nil {
+			t.Fatalf("Allow %d within the burst: %v", i, err)
+		}
+	}
+	err := quota(t, c.Allow(1, 0, 0))
+	if err.SourceID != 1 || err.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Allow over the burst = %+v, want source 1 to retry after 500ms", err)
+	}
+
+	// other sources have their own bucket, unknown ones no limit at all
+	if err := c.Allow(2, 0, 0); err != nil {
+		t.Errorf("Allow(2) = %v", err)
+	}
+
+	*now = now.Add(250 * time.Millisecond)
+	if err := quota(t, c.Allow(1, 0, 0)); err.RetryAfter != 250*time.Millisecond {
+		t.Errorf("RetryAfter with half a token = %v, want 250ms", err.RetryAfter)
+	}
+	*now = now.Add(250 * time.Millisecond)
+	if err := c.Allow(1, 0, 0); err != nil {
+		t.Errorf("Allow after a token came back: %v", err)
+	}
+
+	// an idle source gets no more than its burst
+	*now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := c.Allow(1, 0, 0); err != nil {
+			t.Fatalf("Allow %d after idling: %v", i, err)
+		}
+	}
+	quota(t, c.Allow(1, 0, 0))
+}
+
+func TestMaxBuffered(t *testing.T) {
+	c, _ := newController(t, config.Admission{RetryAfter: 3 * time.Second}, config.Source{ID: 1, MaxBuffered: 2, Rate: 1, Burst: 2})
+
+	if err := c.Allow(1, 1, 0); err != nil {
+		t.Fatalf("Allow below the limit: %v", err)
+	}
+	err := quota(t, c.Allow(1, 2, 0))
+	if err.RetryAfter != 3*time.Second {
+		t.Errorf("RetryAfter = %v, want the configured 3s", err.RetryAfter)
+	}
+	if got := err.RetryAfterSeconds(); got != "3" {
+		t.Errorf("RetryAfterSeconds = %q, want 3", got)
+	}
+
+	// the refused test took no token, one is left
+	if err := c.Allow(1, 0, 0); err != nil {
+		t.Errorf("Allow with a token left: %v", err)
+	}
+	if err := c.Allow(1, 0, 0); err == nil {
+		t.Errorf("Allow with an empty bucket succeeded")
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	c, _ := newController(t, config.Admission{MaxInFlight: 2, RetryAfter: time.Second})
+
+	if err := c.Allow(1, 0, 1); err != nil {
+		t.Fatalf("Allow below the limit: %v", err)
+	}
+	if err := quota(t, c.Allow(1, 0, 2)); err.SourceID != 0 || err.RetryAfter != time.Second {
+		t.Errorf("Allow at the limit = %+v", err)
+	}
+
+	unlimited, _ := newController(t, config.Admission{})
+	if err := unlimited.Allow(1, 0, 1000); err != nil {
+		t.Errorf("Allow without a limit: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package buffers lays the named circular buffers out end to end in one
+// position space and routes test requests to them. A buffer owns the
+// positions [Offset, Offset+Size), so the storages keep addressing buffered
+// tests by position alone. Changing the layout between runs moves the tests
+// left in the buffer to whichever buffer owns their position now.
+package buffers
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/sources"
+	"fmt"
+)
+
+// DefaultName is the name of the buffer built from config.CycleBufferConfig
+// when no named buffers are configured.
+const DefaultName = "default"
+
+type Buffer struct {
+	Name           string
+	Offset         int64
+	Size           int64
+	EvictionPolicy string
+	Priority       int
+	Weight         int
+}
+
+// Contains reports whether pos belongs to b.
+func (b Buffer) Contains(pos int64) bool {
+	return pos >= b.Offset && pos < b.Offset+b.Size
+}
+
+// Free returns the first position of b at or after cursor, wrapping around,
+// that is not taken. ok is false when the buffer is full.
+func (b Buffer) Free(cursor int64, taken func(pos int64) bool) (pos int64, ok bool) {
+	if !b.Contains(cursor) {
+		cursor = b.Offset
+	}
+	for i := int64(0); i < b.Size; i++ {
+		pos = b.Offset + (cursor-b.Offset+i)%b.Size
+		if !taken(pos) {
+			return pos, true
+		}
+	}
+	return cursor, false
+}
+
+type route struct {
+	buffer     int
+	sources    map[uint]bool
+	priorities map[int]bool
+}
+
+// Layout is the configured set of buffers and the routes between them.
+type Layout struct {
+	buffers []Buffer
+	routes  []route
+	sources *sources.Table
+}
+
+func New(cfg *config.Config, table *sources.Table) (*Layout, error) {
+	const op = "buffers.New"
+
+	if err := cfg.CheckBuffers(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	list := cfg.Buffers
+	if len(list) == 0 {
+		list = []config.Buffer{{
+			Name:           DefaultName,
+			MaxSize:        cfg.CycleBufferConfig.MaxSize,
+			EvictionPolicy: cfg.CycleBufferConfig.EvictionPolicy,
+		}}
+	}
+
+	l := &Layout{sources: table}
+	byName := make(map[string]int, len(list))
+	var offset int64
+	for i, c := range list {
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("%s: buffer %d has no name", op, i)
+		case c.MaxSize <= 0:
+			return nil, fmt.Errorf("%s: buffer %q: max_size %d is not positive", op, c.Name, c.MaxSize)
+		case c.Weight < 0:
+			return nil, fmt.Errorf("%s: buffer %q: weight %d is negative", op, c.Name, c.Weight)
+		}
+		if _, ok := byName[c.Name]; ok {
+			return nil, fmt.Errorf("%s: buffer %q is configured twice", op, c.Name)
+		}
+		byName[c.Name] = i
+
+		b := Buffer{
+			Name:           c.Name,
+			Offset:         offset,
+			Size:           c.MaxSize,
+			EvictionPolicy: c.EvictionPolicy,
+			Priority:       c.Priority,
+			Weight:         c.Weight,
+		}
+		if b.Weight == 0 {
+			b.Weight = 1
+		}
+		l.buffers = append(l.buffers, b)
+		offset += c.MaxSize
+	}
+
+	for i, c := range cfg.Routes {
+		idx, ok := byName[c.Buffer]
+		if !ok {
+			return nil, fmt.Errorf("%s: route %d: unknown buffer %q", op, i, c.Buffer)
+		}
+		r := route{
+			buffer:     idx,
+			sources:    make(map[uint]bool, len(c.Sources)),
+			priorities: make(map[int]bool, len(c.Priorities)),
+		}
+		for _, id := range c.Sources {
+			r.sources[id] = true
+		}
+		for _, p := range c.Priorities {
+			r.priorities[p] = true
+		}
+		l.routes = append(l.routes, r)
+	}
+
+	return l, nil
+}
+
+// Buffers returns the buffers in the configured order.
+func (l *Layout) Buffers() []Buffer {
+	return append([]Buffer(nil), l.buffers...)
+}
+
+// At returns the buffer with index i, as returned by Route and Of.
+func (l *Layout) At(i int) Buffer {
+	return l.buffers[i]
+}
+
+// Size is the total size of all buffers.
+func (l *Layout) Size() int64 {
+	last := l.buffers[len(l.buffers)-1]
+	return last.Offset + last.Size
+}
+
+// Route returns the index of the buffer the tests of sourceID go to.
+func (l *Layout) Route(sourceID uint) int {
+	priority := l.sources.Get(sourceID).Priority
+	for _, r := range l.routes {
+		if r.sources[sourceID] || r.priorities[priority] {
+			return r.buffer
+		}
+	}
+	return 0
+}
+
+// Of returns the index of the buffer that owns pos, or false for a position
+// outside the layout.
+func (l *Layout) Of(pos int64) (int, bool) {
+	for i, b := range l.buffers {
+		if b.Contains(pos) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Name returns the name of the buffer that owns pos, empty outside the layout.
+func (l *Layout) Name(pos int64) string {
+	if i, ok := l.Of(pos); ok {
+		return l.buffers[i].Name
+	}
+	return ""
+}
+
+// Get returns the buffer called name.
+func (l *Layout) Get(name string) (Buffer, bool) {
+	for _, b := range l.buffers {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Buffer{}, false
+}
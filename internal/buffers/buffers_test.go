@@ -0,0 +1,117 @@
+package buffers
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/sources"
+	"testing"
+)
+
+func TestNewValidates(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{name: "no name", cfg: config.Config{Buffers: []config.Buffer{{MaxSize: 1}}}},
+		{name: "no size", cfg: config.Config{Buffers: []config.Buffer{{Name: "a"}}}},
+		{name: "negative weight", cfg: config.Config{Buffers: []config.Buffer{{Name: "a", MaxSize: 1, Weight: -1}}}},
+		{name: "duplicate", cfg: config.Config{Buffers: []config.Buffer{{Name: "a", MaxSize: 1}, {Name: "a", MaxSize: 1}}}},
+		{name: "unknown route", cfg: config.Config{
+			Buffers: []config.Buffer{{Name: "a", MaxSize: 1}},
+			Routes:  []config.Route{{Buffer: "b", Sources: []uint{1}}},
+		}},
+		{name: "empty cycle buffer"},
+		{name: "cycle buffer disagrees", cfg: config.Config{
+			CycleBufferConfig: config.CycleBufferConfig{MaxSize: 5},
+			Buffers:           []config.Buffer{{Name: DefaultName, MaxSize: 10}},
+		}},
+		{name: "cycle buffer without default", cfg: config.Config{
+			CycleBufferConfig: config.CycleBufferConfig{MaxSize: 5},
+			Buffers:           []config.Buffer{{Name: "a", MaxSize: 5}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(&tt.cfg, nil); err == nil {
+				t.Errorf("New accepted %+v", tt.cfg)
+			}
+		})
+	}
+}
+
+func TestDefaultBuffer(t *testing.T) {
+	l, err := New(&config.Config{CycleBufferConfig: config.CycleBufferConfig{MaxSize: 4, EvictionPolicy: "oldest"}}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	b := l.At(l.Route(9))
+	if b.Name != DefaultName || b.Offset != 0 || b.Size != 4 || b.EvictionPolicy != "oldest" || b.Weight != 1 {
+		t.Errorf("default buffer = %+v", b)
+	}
+	if l.Size() != 4 {
+		t.Errorf("Size = %d, want 4", l.Size())
+	}
+}
+
+func TestLayout(t *testing.T) {
+	table, err := sources.New([]config.Source{{ID: 3, Priority: 2}}, config.UnknownSources{})
+	if err != nil {
+		t.Fatalf("sources.New: %v", err)
+	}
+	l, err := New(&config.Config{
+		Buffers: []config.Buffer{
+			{Name: "common", MaxSize: 4},
+			{Name: "vip", MaxSize: 2},
+			{Name: "low", MaxSize: 3},
+		},
+		Routes: []config.Route{
+			{Buffer: "vip", Sources: []uint{1, 2}},
+			{Buffer: "low", Priorities: []int{2}},
+		},
+	}, table)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if l.Size() != 9 {
+		t.Errorf("Size = %d, want 9", l.Size())
+	}
+	for source, want := range map[uint]string{1: "vip", 2: "vip", 3: "low", 4: "common"} {
+		if got := l.At(l.Route(source)).Name; got != want {
+			t.Errorf("source %d is routed to %q, want %q", source, got, want)
+		}
+	}
+	for pos, want := range map[int64]string{0: "common", 3: "common", 4: "vip", 5: "vip", 6: "low", 8: "low", 9: ""} {
+		if got := l.Name(pos); got != want {
+			t.Errorf("Name(%d) = %q, want %q", pos, got, want)
+		}
+	}
+	if b, ok := l.Get("vip"); !ok || b.Offset != 4 {
+		t.Errorf("Get(vip) = %+v, %v", b, ok)
+	}
+}
+
+func TestFree(t *testing.T) {
+	b := Buffer{Offset: 4, Size: 3}
+	taken := map[int64]bool{5: true}
+	isTaken := func(pos int64) bool { return taken[pos] }
+
+	for _, tt := range []struct {
+		cursor, want int64
+	}{
+		{cursor: 4, want: 4},
+		{cursor: 5, want: 6},
+		{cursor: 0, want: 4},
+		{cursor: 7, want: 4},
+	} {
+		if pos, ok := b.Free(tt.cursor, isTaken); !ok || pos != tt.want {
+			t.Errorf("Free(%d) = %d, %v, want %d", tt.cursor, pos, ok, tt.want)
+		}
+	}
+
+	taken[4], taken[6] = true, true
+	if _, ok := b.Free(6, isTaken); ok {
+		t.Errorf("Free found a position in a full buffer")
+	}
+}
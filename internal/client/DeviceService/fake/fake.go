@@ -0,0 +1,174 @@
+package fakeDevice
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Distribution produces service times of a device.
+type Distribution interface {
+	Next() time.Duration
+}
+
+type constant time.Duration
+
+// Constant returns a distribution that always yields d.
+func Constant(d time.Duration) Distribution {
+	return constant(d)
+}
+
+func (c constant) Next() time.Duration {
+	return time.Duration(c)
+}
+
+type uniform struct {
+	mu       sync.Mutex
+	rnd      *rand.Rand
+	min, max time.Duration
+}
+
+// Uniform returns a distribution uniform on [min, max).
+func Uniform(min, max time.Duration, rnd *rand.Rand) Distribution {
+	return &uniform{rnd: rnd, min: min, max: max}
+}
+
+func (u *uniform) Next() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.max <= u.min {
+		return u.min
+	}
+	return u.min + time.Duration(u.rnd.Int63n(int64(u.max-u.min)))
+}
+
+type exponential struct {
+	mu   sync.Mutex
+	rnd  *rand.Rand
+	mean time.Duration
+}
+
+// Exponential returns an exponential distribution with the given mean.
+func Exponential(mean time.Duration, rnd *rand.Rand) Distribution {
+	return &exponential{rnd: rnd, mean: mean}
+}
+
+func (e *exponential) Next() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return time.Duration(e.rnd.ExpFloat64() * float64(e.mean))
+}
+
+// Served describes one test a fake device took.
+type Served struct {
+	DeviceID   int32
+	SourceID   uint32
+	TestNumber uint32
+	Started    time.Time
+	Duration   time.Duration
+}
+
+// Service is an in-process DeviceService. Each device stays busy for a
+// service time drawn from the distribution after it takes a test.
+type Service struct {
+	device.UnimplementedDeviceServiceServer
+
+	mu        sync.Mutex
+	service   Distribution
+	busyUntil map[int32]time.Time
+	ids       []int32
+	served    []Served
+}
+
+// New returns a service with devices numbered from 1 to devices.
+func New(devices int, service Distribution) *Service {
+	s := &Service{
+		service:   service,
+		busyUntil: make(map[int32]time.Time, devices),
+	}
+	for id := int32(1); id <= int32(devices); id++ {
+		s.ids = append(s.ids, id)
+		s.busyUntil[id] = time.Time{}
+	}
+	return s
+}
+
+// GetDeviceList returns the devices that are free right now.
+func (s *Service) GetDeviceList(context.Context, *emptypb.Empty) (*device.DeviceListResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	resp := &device.DeviceListResponse{}
+	for _, id := range s.ids {
+		if !s.busyUntil[id].After(now) {
+			resp.Devices = append(resp.Devices, &device.DeviceResponse{DeviceId: id})
+		}
+	}
+
+	return resp, nil
+}
+
+// SendTest occupies the device. It fails for unknown and busy devices.
+func (s *Service) SendTest(_ context.Context, req *device.TestRequest) (*device.TestResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := int32(req.DeviceId)
+	busyUntil, ok := s.busyUntil[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "device %d doesn't exist", id)
+	}
+
+	now := time.Now()
+	if busyUntil.After(now) {
+		return nil, status.Errorf(codes.Unavailable, "device %d is busy", id)
+	}
+
+	d := s.service.Next()
+	s.busyUntil[id] = now.Add(d)
+	s.served = append(s.served, Served{
+		DeviceID:   id,
+		SourceID:   req.SourceId,
+		TestNumber: req.TestNumber,
+		Started:    now,
+		Duration:   d,
+	})
+
+	return &device.TestResponse{Status: true}, nil
+}
+
+// Served returns every test taken so far in the order devices took them.
+func (s *Service) Served() []Served {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Served(nil), s.served...)
+}
+
+// Start serves the service over gRPC on addr, use "127.0.0.1:0" for a free
+// port. It returns the address to dial and a function that stops the server.
+func (s *Service) Start(addr string) (string, func(), error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, fmt.Errorf("Can't listen on %s: %w", addr, err)
+	}
+
+	srv := grpc.NewServer()
+	device.RegisterDeviceServiceServer(srv, s)
+
+	go srv.Serve(lis)
+
+	return lis.Addr().String(), srv.Stop, nil
+}
@@ -0,0 +1,57 @@
+package fakeDevice
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestBusyDeviceIsHidden(t *testing.T) {
+	s := New(2, Constant(time.Hour))
+	ctx := context.Background()
+
+	if _, err := s.SendTest(ctx, &device.TestRequest{DeviceId: 1, SourceId: 1, TestNumber: 1}); err != nil {
+		t.Fatalf("SendTest: %v", err)
+	}
+
+	list, _ := s.GetDeviceList(ctx, &emptypb.Empty{})
+	if len(list.Devices) != 1 || list.Devices[0].DeviceId != 2 {
+		t.Fatalf("free devices = %v, want only device 2", list.Devices)
+	}
+
+	if _, err := s.SendTest(ctx, &device.TestRequest{DeviceId: 1, SourceId: 1, TestNumber: 2}); err == nil {
+		t.Errorf("busy device took a second test")
+	}
+	if _, err := s.SendTest(ctx, &device.TestRequest{DeviceId: 9}); err == nil {
+		t.Errorf("unknown device took a test")
+	}
+
+	if served := s.Served(); len(served) != 1 || served[0].Duration != time.Hour {
+		t.Errorf("served = %+v", served)
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	u := Uniform(10*time.Millisecond, 20*time.Millisecond, rnd)
+	for i := 0; i < 100; i++ {
+		if d := u.Next(); d < 10*time.Millisecond || d >= 20*time.Millisecond {
+			t.Fatalf("uniform sample %v out of range", d)
+		}
+	}
+
+	const mean = 100 * time.Millisecond
+	e := Exponential(mean, rnd)
+	var sum time.Duration
+	for i := 0; i < 10000; i++ {
+		sum += e.Next()
+	}
+	if avg := sum / 10000; avg < 90*time.Millisecond || avg > 110*time.Millisecond {
+		t.Errorf("exponential sample mean = %v, want about %v", avg, mean)
+	}
+}
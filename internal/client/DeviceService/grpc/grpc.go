@@ -11,6 +11,7 @@ import (
 )
 
 type Client struct {
+	cc  *grpc.ClientConn
 	api device.DeviceServiceClient
 	log *slog.Logger
 }
@@ -25,6 +26,7 @@ func New(
 	}
 
 	return &Client{
+		cc:  cc,
 		api: device.NewDeviceServiceClient(cc),
 		log: log,
 	}, nil
@@ -49,3 +51,7 @@ func (c *Client) SendTest(ctx context.Context, deviceId, sourceId, testNumber in
 	}
 	return nil
 }
+
+func (c *Client) Close() error {
+	return c.cc.Close()
+}
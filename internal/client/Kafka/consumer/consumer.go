@@ -0,0 +1,200 @@
+// Package consumer reads test requests from a Kafka topic and admits them
+// like POST /test does.
+package consumer
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// client is the part of *kafka.Consumer the consumer uses.
+type client interface {
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	CommitMessage(m *kafka.Message) ([]kafka.TopicPartition, error)
+	Close() error
+}
+
+// Admitter runs a request through admission, test.Handler implements it.
+// TryAdmit leaves no trace of a throttled request, the consumer retries it.
+type Admitter interface {
+	TryAdmit(log *slog.Logger, req test.TestRequest) (test.AdmitResult, error)
+}
+
+// Consumer admits every request from the input topic and commits its offset
+// only after the request was buffered, dispatched or refused. A request that
+// fails admission is retried until it succeeds, so offsets never skip it.
+type Consumer struct {
+	log          *slog.Logger
+	client       client
+	admitter     Admitter
+	pollTimeout  time.Duration
+	retryBackoff time.Duration
+
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func New(log *slog.Logger, cfg config.KafkaConsumer, admitter Admitter) (*Consumer, error) {
+	const op = "client.kafka.consumer.New"
+
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  cfg.Broker,
+		"group.id":           cfg.GroupID,
+		"enable.auto.commit": false,
+		"auto.offset.reset":  "earliest",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := c.SubscribeTopics([]string{cfg.Topic}, nil); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return newConsumer(log, c, admitter, cfg), nil
+}
+
+func newConsumer(log *slog.Logger, c client, admitter Admitter, cfg config.KafkaConsumer) *Consumer {
+	return &Consumer{
+		log:          log.With(slog.String("component", "kafka.Consumer")),
+		client:       c,
+		admitter:     admitter,
+		pollTimeout:  cfg.PollTimeout,
+		retryBackoff: cfg.RetryBackoff,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Start runs the consume loop in its own goroutine. Calling it twice is a no-op.
+func (c *Consumer) Start() {
+	c.startOnce.Do(func() {
+		go c.run()
+	})
+}
+
+// Stop asks the loop to exit after the current request, waits for it and
+// closes the consumer, leaving the group.
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.startOnce.Do(func() {
+		close(c.done)
+	})
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := c.client.Close(); err != nil {
+		return fmt.Errorf("close kafka consumer: %w", err)
+	}
+	return nil
+}
+
+func (c *Consumer) run() {
+	defer close(c.done)
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+
+		msg, err := c.client.ReadMessage(c.pollTimeout)
+		if err != nil {
+			var kerr kafka.Error
+			if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
+				continue
+			}
+			c.log.Error("failed to read kafka message", slog.Any("error", err))
+			c.sleep(c.retryBackoff)
+			continue
+		}
+
+		if !c.handle(msg) {
+			return
+		}
+
+		if _, err := c.client.CommitMessage(msg); err != nil {
+			c.log.Error("failed to commit kafka offset", slog.Any("offset", msg.TopicPartition), slog.Any("error", err))
+		}
+	}
+}
+
+// handle admits the request in msg, retrying until it succeeds. It returns
+// false when the consumer was stopped before that.
+func (c *Consumer) handle(msg *kafka.Message) bool {
+	var req test.TestRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		// it will never decode, committing skips it
+		c.log.Error("dropping malformed test request",
+			slog.Any("offset", msg.TopicPartition),
+			slog.String("value", string(msg.Value)),
+			slog.Any("error", err),
+		)
+		return true
+	}
+	if err := req.Validate(); err != nil {
+		c.log.Error("dropping invalid test request",
+			slog.Any("offset", msg.TopicPartition),
+			slog.Any("request", req),
+			slog.Any("error", err),
+		)
+		return true
+	}
+
+	log := c.log.With(slog.Any("request", req), slog.Any("offset", msg.TopicPartition))
+	for {
+		result, err := c.admitter.TryAdmit(log, req)
+		if err == nil {
+			log.Info("test request admitted", slog.String("result", result.String()))
+			return true
+		}
+		if errors.Is(err, test.ErrUnknownSource) {
+			log.Error("dropping test request from unknown source", slog.Any("error", err))
+			return true
+		}
+
+		backoff := c.retryBackoff
+		var quotaErr *test.QuotaError
+		if errors.As(err, &quotaErr) {
+			log.Warn("test request throttled, will retry", slog.Any("error", err))
+			backoff = quotaErr.RetryAfter
+		} else {
+			log.Error("failed to admit test request, will retry", slog.Any("error", err))
+		}
+		if !c.sleep(backoff) {
+			return false
+		}
+	}
+}
+
+// sleep waits for d and returns false when stopped meanwhile.
+func (c *Consumer) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-c.stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
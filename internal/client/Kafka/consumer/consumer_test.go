@@ -0,0 +1,215 @@
+package consumer
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/dispatcher"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/storage/memory"
+	"Dispatcher/internal/storage/storagetest"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type fakeClient struct {
+	mu        sync.Mutex
+	messages  []*kafka.Message
+	committed []kafka.Offset
+	closed    bool
+}
+
+func (c *fakeClient) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.messages) == 0 {
+		time.Sleep(timeout)
+		return nil, kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+	}
+	msg := c.messages[0]
+	c.messages = c.messages[1:]
+	return msg, nil
+}
+
+func (c *fakeClient) CommitMessage(m *kafka.Message) ([]kafka.TopicPartition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.committed = append(c.committed, m.TopicPartition.Offset)
+	return nil, nil
+}
+
+func (c *fakeClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+func (c *fakeClient) commits() []kafka.Offset {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]kafka.Offset(nil), c.committed...)
+}
+
+// fakeAdmitter fails the first failures calls.
+type fakeAdmitter struct {
+	mu       sync.Mutex
+	failures int
+	admitted []test.TestRequest
+}
+
+func (a *fakeAdmitter) TryAdmit(_ *slog.Logger, req test.TestRequest) (test.AdmitResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.failures > 0 {
+		a.failures--
+		return test.AdmitResult{}, errors.New("storage is down")
+	}
+	a.admitted = append(a.admitted, req)
+	return test.AdmitResult{SourceID: req.SourceID, TestNumber: req.TestNumber, Outcome: test.OutcomeBuffered}, nil
+}
+
+func message(offset kafka.Offset, value string) *kafka.Message {
+	return &kafka.Message{TopicPartition: kafka.TopicPartition{Offset: offset}, Value: []byte(value)}
+}
+
+func newTestConsumer(c client, a Admitter) *Consumer {
+	return newConsumer(slog.New(slog.NewTextHandler(io.Discard, nil)), c, a, config.KafkaConsumer{
+		PollTimeout:  time.Millisecond,
+		RetryBackoff: time.Millisecond,
+	})
+}
+
+func waitCommits(t *testing.T, c *fakeClient, n int) []kafka.Offset {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.commits()) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return c.commits()
+}
+
+func TestConsumerCommitsAfterAdmission(t *testing.T) {
+	client := &fakeClient{messages: []*kafka.Message{
+		message(1, `{"source_id": 1, "test_number": 1}`),
+		message(2, `not json`),
+		message(3, `{"source_id": 2, "test_number": 5}`),
+		message(4, `{"source_id": 3}`),
+	}}
+	admitter := &fakeAdmitter{failures: 2}
+
+	c := newTestConsumer(client, admitter)
+	c.Start()
+
+	got := waitCommits(t, client, 4)
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if len(got) != 4 || got[0] != 1 || got[1] != 2 || got[2] != 3 || got[3] != 4 {
+		t.Fatalf("committed offsets = %v, want [1 2 3 4]", got)
+	}
+	want := []test.TestRequest{{SourceID: 1, TestNumber: 1}, {SourceID: 2, TestNumber: 5}}
+	if len(admitter.admitted) != 2 || admitter.admitted[0] != want[0] || admitter.admitted[1] != want[1] {
+		t.Errorf("admitted = %v, want %v", admitter.admitted, want)
+	}
+	if !client.closed {
+		t.Errorf("consumer was not closed")
+	}
+}
+
+func TestConsumerStopWhileRetrying(t *testing.T) {
+	client := &fakeClient{messages: []*kafka.Message{message(1, `{"source_id": 1, "test_number": 1}`)}}
+	admitter := &fakeAdmitter{failures: 1 << 30}
+
+	c := newTestConsumer(client, admitter)
+	c.Start()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if got := client.commits(); len(got) != 0 {
+		t.Errorf("committed %v for a request that was never admitted", got)
+	}
+}
+
+// throttle refuses the first refusals tests and lets the rest through.
+type throttle struct {
+	mu       sync.Mutex
+	refusals int
+}
+
+func (a *throttle) Allow(sourceID uint, _, _ int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.refusals > 0 {
+		a.refusals--
+		return &test.QuotaError{SourceID: sourceID, Reason: "slow down", RetryAfter: time.Millisecond}
+	}
+	return nil
+}
+
+type noDevices struct{}
+
+func (noDevices) GetDeviceList(context.Context) ([]*device.DeviceResponse, error) { return nil, nil }
+func (noDevices) SendTest(context.Context, int32, int32, int32) error             { return nil }
+
+type noNotifier struct{}
+
+func (noNotifier) Notify() {}
+
+func TestConsumerRetriesThrottledTestOnce(t *testing.T) {
+	cfg := &config.Config{
+		GRPCClient:        config.GRPCClient{Timeout: time.Second},
+		CycleBufferConfig: config.CycleBufferConfig{MaxSize: 4},
+		// there is no producer for the legacy message
+		KafkaProducer: config.KafkaProducer{DisableLegacyData: true},
+	}
+	table, layout := storagetest.Layout(t, cfg)
+	st, err := memory.New(slog.New(slog.NewTextHandler(io.Discard, nil)), table, layout)
+	if err != nil {
+		t.Fatalf("memory.New: %v", err)
+	}
+	handler := test.NewHandler(st, noDevices{}, nil, dispatcher.NewRegistry(time.Minute), noNotifier{}, nil, nil, &throttle{refusals: 2}, cfg)
+
+	client := &fakeClient{messages: []*kafka.Message{message(1, `{"source_id": 1, "test_number": 1}`)}}
+	c := newTestConsumer(client, handler)
+	c.Start()
+
+	got := waitCommits(t, client, 1)
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("committed offsets = %v, want [1]", got)
+	}
+
+	history, err := st.GetLifecycle(1, 1)
+	if err != nil {
+		t.Fatalf("GetLifecycle: %v", err)
+	}
+	var states []string
+	for _, tr := range history {
+		states = append(states, tr.State)
+	}
+	if len(states) != 2 || states[0] != test.StateReceived || states[1] != test.StateBuffered {
+		t.Errorf("lifecycle = %v, want one received and buffered", states)
+	}
+}
@@ -0,0 +1,359 @@
+// Package producer wraps the Kafka producer: it reads delivery reports, keeps
+// undelivered messages in a bounded spool and retries them, and reports
+// whether the producer is healthy.
+package producer
+
+import (
+	"Dispatcher/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// ErrSpoolFull is returned by Produce when a message could not be queued and
+// the spool has no room for it either.
+var ErrSpoolFull = errors.New("kafka spool is full")
+
+// client is the part of *kafka.Producer the wrapper uses.
+type client interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	Events() chan kafka.Event
+	Flush(timeoutMs int) int
+	Close()
+}
+
+// Counters describe what happened to produced messages so far.
+type Counters struct {
+	Produced  int64 `json:"produced"`
+	Delivered int64 `json:"delivered"`
+	// Failed counts delivery reports with an error, Retried the messages that
+	// were produced again from the spool.
+	Failed  int64 `json:"failed"`
+	Retried int64 `json:"retried"`
+	// Dropped counts messages given up on: out of retries or pushed out of a
+	// full spool.
+	Dropped int64 `json:"dropped"`
+	Spooled int   `json:"spooled"`
+}
+
+// attempts is stored in kafka.Message.Opaque.
+type attempts int
+
+type Producer struct {
+	log           *slog.Logger
+	client        client
+	spoolSize     int
+	maxRetries    int
+	retryInterval time.Duration
+
+	mu        sync.Mutex
+	spool     []*kafka.Message
+	counters  Counters
+	brokerErr error
+	fatalErr  error
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func New(log *slog.Logger, cfg config.KafkaProducer) (*Producer, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": cfg.Broker})
+	if err != nil {
+		return nil, fmt.Errorf("client.kafka.producer.New: %w", err)
+	}
+
+	return newProducer(log, p, cfg), nil
+}
+
+func newProducer(log *slog.Logger, c client, cfg config.KafkaProducer) *Producer {
+	p := &Producer{
+		log:           log.With(slog.String("component", "kafka.Producer")),
+		client:        c,
+		spoolSize:     cfg.SpoolSize,
+		maxRetries:    cfg.MaxRetries,
+		retryInterval: cfg.RetryInterval,
+		stop:          make(chan struct{}),
+	}
+
+	p.wg.Add(2)
+	go p.handleEvents()
+	go p.retryLoop()
+
+	return p
+}
+
+// Produce queues msg for delivery. The delivery channel is ignored, reports
+// are always read by the producer itself. When the local queue refuses the
+// message it goes to the spool.
+func (p *Producer) Produce(msg *kafka.Message, _ chan kafka.Event) error {
+	p.mu.Lock()
+	p.counters.Produced++
+	p.mu.Unlock()
+
+	if err := p.client.Produce(msg, nil); err != nil {
+		p.log.Warn("failed to queue kafka message, spooling it", slog.Any("error", err))
+		if !p.toSpool(msg) {
+			return fmt.Errorf("%w: %v", ErrSpoolFull, err)
+		}
+	}
+	return nil
+}
+
+// deliveryIndex is stored in kafka.Message.Opaque by Deliver.
+type deliveryIndex int
+
+// Deliver produces msgs and waits for their delivery reports. It returns how
+// many messages from the start of msgs were delivered, so a caller that needs
+// them in order can resend from the first failure. Deliver doesn't use the
+// spool, retrying is up to the caller.
+func (p *Producer) Deliver(ctx context.Context, msgs []*kafka.Message) (int, error) {
+	reports := make(chan kafka.Event, len(msgs))
+
+	produced := 0
+	var produceErr error
+	for i, msg := range msgs {
+		msg.Opaque = deliveryIndex(i)
+		if produceErr = p.client.Produce(msg, reports); produceErr != nil {
+			break
+		}
+		produced++
+	}
+
+	p.mu.Lock()
+	p.counters.Produced += int64(produced)
+	p.mu.Unlock()
+
+	delivered := make([]bool, produced)
+	var deliveryErr error
+	for waiting := produced; waiting > 0; waiting-- {
+		select {
+		case <-ctx.Done():
+			return prefix(delivered), ctx.Err()
+		case ev := <-reports:
+			msg, ok := ev.(*kafka.Message)
+			if !ok {
+				waiting++
+				continue
+			}
+
+			i, _ := msg.Opaque.(deliveryIndex)
+			p.mu.Lock()
+			if msg.TopicPartition.Error != nil {
+				p.counters.Failed++
+				if deliveryErr == nil {
+					deliveryErr = msg.TopicPartition.Error
+				}
+			} else {
+				p.counters.Delivered++
+				p.brokerErr = nil
+				delivered[i] = true
+			}
+			p.mu.Unlock()
+		}
+	}
+
+	n := prefix(delivered)
+	if n < len(msgs) {
+		return n, errors.Join(produceErr, deliveryErr)
+	}
+	return n, nil
+}
+
+func prefix(delivered []bool) int {
+	for i, ok := range delivered {
+		if !ok {
+			return i
+		}
+	}
+	return len(delivered)
+}
+
+// Flush produces the spooled messages once more and waits for outstanding
+// deliveries. It returns how many messages are still not delivered.
+func (p *Producer) Flush(timeoutMs int) int {
+	p.retry()
+	left := p.client.Flush(timeoutMs)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return left + len(p.spool)
+}
+
+// Close stops the retries and closes the underlying producer. Spooled
+// messages are lost.
+func (p *Producer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		p.client.Close()
+		p.wg.Wait()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if len(p.spool) > 0 {
+			p.log.Error("kafka producer closed with undelivered messages", slog.Int("spooled", len(p.spool)))
+		}
+	})
+}
+
+// Ready returns an error while the brokers are unreachable, the producer hit
+// a fatal error or the spool is full.
+func (p *Producer) Ready() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.fatalErr != nil:
+		return fmt.Errorf("kafka producer failed: %w", p.fatalErr)
+	case p.brokerErr != nil:
+		return fmt.Errorf("kafka is unavailable: %w", p.brokerErr)
+	case p.spoolSize > 0 && len(p.spool) >= p.spoolSize:
+		return ErrSpoolFull
+	}
+	return nil
+}
+
+// Details returns the counters, they are shown by the readiness check.
+func (p *Producer) Details() any {
+	return p.Counters()
+}
+
+func (p *Producer) Counters() Counters {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.counters
+	c.Spooled = len(p.spool)
+	return c
+}
+
+func (p *Producer) handleEvents() {
+	defer p.wg.Done()
+
+	for ev := range p.client.Events() {
+		switch e := ev.(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				p.failed(e)
+				continue
+			}
+
+			p.mu.Lock()
+			p.counters.Delivered++
+			p.brokerErr = nil
+			p.mu.Unlock()
+		case kafka.Error:
+			p.log.Error("kafka producer error", slog.Any("error", e))
+
+			p.mu.Lock()
+			if e.IsFatal() {
+				p.fatalErr = e
+			} else if e.Code() == kafka.ErrAllBrokersDown {
+				p.brokerErr = e
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *Producer) failed(msg *kafka.Message) {
+	n, _ := msg.Opaque.(attempts)
+
+	p.mu.Lock()
+	p.counters.Failed++
+	if int(n) >= p.maxRetries {
+		p.counters.Dropped++
+		p.mu.Unlock()
+
+		p.log.Error("kafka message was not delivered, giving up",
+			slog.Any("topic", msg.TopicPartition.Topic),
+			slog.Int("attempts", int(n)+1),
+			slog.Any("error", msg.TopicPartition.Error),
+		)
+		return
+	}
+	p.mu.Unlock()
+
+	p.log.Warn("kafka message was not delivered, will retry",
+		slog.Any("topic", msg.TopicPartition.Topic),
+		slog.Any("error", msg.TopicPartition.Error),
+	)
+
+	msg.Opaque = n + 1
+	msg.TopicPartition.Partition = kafka.PartitionAny
+	msg.TopicPartition.Error = nil
+	p.toSpool(msg)
+}
+
+// toSpool keeps msg for a later retry. A full spool drops its oldest message;
+// with no spool at all msg itself is dropped and false is returned.
+func (p *Producer) toSpool(msg *kafka.Message) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.spoolSize <= 0 {
+		p.counters.Dropped++
+		return false
+	}
+
+	if len(p.spool) >= p.spoolSize {
+		p.spool = p.spool[1:]
+		p.counters.Dropped++
+		p.log.Error("kafka spool is full, dropping the oldest message")
+	}
+	p.spool = append(p.spool, msg)
+	return true
+}
+
+func (p *Producer) retryLoop() {
+	defer p.wg.Done()
+
+	if p.retryInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.retry()
+		}
+	}
+}
+
+// retry produces the spooled messages again. The ones the local queue still
+// refuses go back to the spool.
+func (p *Producer) retry() {
+	p.mu.Lock()
+	spooled := p.spool
+	p.spool = nil
+	p.mu.Unlock()
+
+	for i, msg := range spooled {
+		if err := p.client.Produce(msg, nil); err != nil {
+			p.mu.Lock()
+			p.spool = append(spooled[i:len(spooled):len(spooled)], p.spool...)
+			if over := len(p.spool) - p.spoolSize; over > 0 {
+				p.spool = p.spool[over:]
+				p.counters.Dropped += int64(over)
+			}
+			p.mu.Unlock()
+			return
+		}
+
+		p.mu.Lock()
+		p.counters.Retried++
+		p.mu.Unlock()
+	}
+}
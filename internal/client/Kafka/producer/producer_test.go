@@ -0,0 +1,232 @@
+package producer
+
+import (
+	"Dispatcher/internal/config"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// fakeClient reports every produced message as delivered unless down is set.
+type fakeClient struct {
+	mu        sync.Mutex
+	events    chan kafka.Event
+	down      bool
+	queueFull bool
+	produced  int
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{events: make(chan kafka.Event, 100)}
+}
+
+func (c *fakeClient) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.queueFull {
+		return kafka.NewError(kafka.ErrQueueFull, "queue full", false)
+	}
+	c.produced++
+
+	report := *msg
+	if c.down {
+		report.TopicPartition.Error = errors.New("broker down")
+	}
+	if deliveryChan != nil {
+		deliveryChan <- &report
+	} else {
+		c.events <- &report
+	}
+	return nil
+}
+
+func (c *fakeClient) Events() chan kafka.Event { return c.events }
+func (c *fakeClient) Flush(int) int            { return 0 }
+func (c *fakeClient) Close()                   { close(c.events) }
+
+func (c *fakeClient) set(down, queueFull bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.down, c.queueFull = down, queueFull
+}
+
+func newTestProducer(c client, spool, retries int) *Producer {
+	return newProducer(slog.New(slog.NewTextHandler(io.Discard, nil)), c, config.KafkaProducer{
+		SpoolSize:     spool,
+		MaxRetries:    retries,
+		RetryInterval: 0,
+	})
+}
+
+func message() *kafka.Message {
+	topic := "events"
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          []byte("{}"),
+	}
+}
+
+func waitFor(t *testing.T, p *Producer, cond func(Counters) bool) Counters {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c := p.Counters()
+		if cond(c) {
+			return c
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("counters = %+v", c)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProducerDelivers(t *testing.T) {
+	p := newTestProducer(newFakeClient(), 10, 3)
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Produce(message(), nil); err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+
+	waitFor(t, p, func(c Counters) bool { return c.Delivered == 3 })
+	if err := p.Ready(); err != nil {
+		t.Errorf("Ready = %v, want nil", err)
+	}
+}
+
+func TestProducerRetriesFailedDeliveries(t *testing.T) {
+	c := newFakeClient()
+	c.set(true, false)
+	p := newTestProducer(c, 10, 3)
+	defer p.Close()
+
+	p.Produce(message(), nil)
+	waitFor(t, p, func(c Counters) bool { return c.Failed == 1 && c.Spooled == 1 })
+
+	c.set(false, false)
+	if left := p.Flush(100); left != 0 {
+		t.Errorf("Flush left %d messages", left)
+	}
+	got := waitFor(t, p, func(c Counters) bool { return c.Delivered == 1 })
+	if got.Retried != 1 || got.Spooled != 0 {
+		t.Errorf("counters after retry = %+v", got)
+	}
+}
+
+func TestProducerGivesUpAfterMaxRetries(t *testing.T) {
+	c := newFakeClient()
+	c.set(true, false)
+	p := newTestProducer(c, 10, 1)
+	defer p.Close()
+
+	p.Produce(message(), nil)
+	waitFor(t, p, func(c Counters) bool { return c.Spooled == 1 })
+	p.retry()
+
+	got := waitFor(t, p, func(c Counters) bool { return c.Failed == 2 })
+	if got.Dropped != 1 || got.Spooled != 0 {
+		t.Errorf("counters after the last retry = %+v, want one dropped", got)
+	}
+}
+
+func TestProducerSpoolIsBounded(t *testing.T) {
+	c := newFakeClient()
+	c.set(false, true)
+	p := newTestProducer(c, 2, 3)
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Produce(message(), nil); err != nil {
+			t.Fatalf("Produce %d: %v", i, err)
+		}
+	}
+
+	got := p.Counters()
+	if got.Spooled != 2 || got.Dropped != 1 {
+		t.Errorf("counters = %+v, want 2 spooled and 1 dropped", got)
+	}
+	if err := p.Ready(); !errors.Is(err, ErrSpoolFull) {
+		t.Errorf("Ready = %v, want ErrSpoolFull", err)
+	}
+
+	full := newFakeClient()
+	full.set(false, true)
+	unspooled := newTestProducer(full, 0, 3)
+	defer unspooled.Close()
+
+	if err := unspooled.Produce(message(), nil); !errors.Is(err, ErrSpoolFull) {
+		t.Errorf("Produce without a spool = %v, want ErrSpoolFull", err)
+	}
+}
+
+func TestProducerBrokersDown(t *testing.T) {
+	c := newFakeClient()
+	p := newTestProducer(c, 10, 3)
+	defer p.Close()
+
+	c.events <- kafka.NewError(kafka.ErrAllBrokersDown, "all brokers down", false)
+	deadline := time.Now().Add(2 * time.Second)
+	for p.Ready() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if p.Ready() == nil {
+		t.Fatalf("Ready = nil while all brokers are down")
+	}
+
+	p.Produce(message(), nil)
+	waitFor(t, p, func(c Counters) bool { return c.Delivered == 1 })
+	if err := p.Ready(); err != nil {
+		t.Errorf("Ready after a delivery = %v, want nil", err)
+	}
+}
+
+// failingClient fails the delivery of every message after the first ok ones.
+type failingClient struct {
+	*fakeClient
+	ok int
+}
+
+func (c *failingClient) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	report := *msg
+	if c.ok == 0 {
+		report.TopicPartition.Error = errors.New("broker down")
+	} else {
+		c.ok--
+	}
+	deliveryChan <- &report
+	return nil
+}
+
+func TestProducerDeliver(t *testing.T) {
+	p := newTestProducer(newFakeClient(), 10, 3)
+	defer p.Close()
+
+	msgs := []*kafka.Message{message(), message(), message()}
+	n, err := p.Deliver(context.Background(), msgs)
+	if n != 3 || err != nil {
+		t.Fatalf("Deliver = %d, %v, want 3, nil", n, err)
+	}
+
+	failing := newTestProducer(&failingClient{fakeClient: newFakeClient(), ok: 1}, 10, 3)
+	defer failing.Close()
+
+	n, err = failing.Deliver(context.Background(), []*kafka.Message{message(), message(), message()})
+	if n != 1 || err == nil {
+		t.Fatalf("Deliver with a failing broker = %d, %v, want 1 and an error", n, err)
+	}
+	if got := failing.Counters(); got.Delivered != 1 || got.Failed != 2 || got.Spooled != 0 {
+		t.Errorf("counters = %+v, want 1 delivered, 2 failed, nothing spooled", got)
+	}
+}
@@ -1,29 +1,188 @@
+// Package httpclient is the client of UserService's HTTP API. Requests that
+// fail on the network, with 429 or with a 5xx status are retried with
+// jittered exponential backoff.
 package httpclient
 
 import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
 	"bytes"
+	"context"
 	"encoding/json"
-	"log/slog"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// maxErrorBody bounds how much of an error response ends up in StatusError.
+const maxErrorBody = 4 << 10
+
+// Response is the result of a test a device finished.
 type Response struct {
 	TestNum int32  `json:"testNum"`
 	Result  bool   `json:"result"`
 	Msg     string `json:"msg"`
 }
 
-func SendResponce(response Response, log *slog.Logger) {
-	data, err := json.Marshal(response)
+// Refusal tells a source that the buffer dropped its test. Status is always
+// false, TestReq and Status are what UserService got before refusals carried
+// the times.
+type Refusal struct {
+	TestReq     test.TestRequest `json:"test_req"`
+	Status      bool             `json:"status"`
+	Reason      string           `json:"reason"`
+	ArrivalTime time.Time        `json:"arrival_time"`
+	RemovalTime time.Time        `json:"removal_time"`
+}
+
+// StatusError is returned when UserService answers with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+	// Message is the message or error field of a JSON body, or the body as is.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("user service responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("user service responded %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Temporary reports whether the request may succeed when retried.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type Client struct {
+	baseURL    string
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+	// jitter picks the actual wait from the exponential backoff d.
+	jitter func(d time.Duration) time.Duration
+}
+
+func New(cfg config.UserService) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(cfg.BaseURL, "/"),
+		client:     &http.Client{Timeout: cfg.Timeout},
+		maxRetries: cfg.MaxRetries,
+		backoff:    cfg.RetryBackoff,
+		jitter:     halfJitter,
+	}
+}
+
+// halfJitter waits between d/2 and d.
+func halfJitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// SendResult tells UserService how a test went.
+func (c *Client) SendResult(ctx context.Context, resp Response) error {
+	if err := c.post(ctx, "/", resp); err != nil {
+		return fmt.Errorf("client.UserService.SendResult: %w", err)
+	}
+	return nil
+}
+
+// SendRefusal tells UserService that t was evicted or rejected by the buffer.
+func (c *Client) SendRefusal(ctx context.Context, t test.TrashTest) error {
+	err := c.post(ctx, "/test", Refusal{
+		TestReq:     t.TestRequest,
+		Reason:      t.Reason,
+		ArrivalTime: t.ArrivalTime,
+		RemovalTime: t.RemovalTime,
+	})
+	if err != nil {
+		return fmt.Errorf("client.UserService.SendRefusal: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, path string, body any) error {
+	data, err := json.Marshal(body)
 	if err != nil {
-		log.Error("Serialization error", "error", err)
-		return
+		return err
 	}
 
-	resp, err := http.Post("http://localhost:8081/", "application/json", bytes.NewBuffer(data))
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err = c.do(ctx, path, data)
+		if err == nil || attempt >= c.maxRetries || ctx.Err() != nil || !temporary(err) {
+			return err
+		}
+
+		t := time.NewTimer(c.jitter(backoff))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, path string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(data))
 	if err != nil {
-		log.Error("POST error", "error", err)
-		return
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return &StatusError{StatusCode: resp.StatusCode, Message: errorMessage(body)}
+}
+
+// errorMessage takes the message out of a JSON error body like the ones the
+// dispatcher itself sends, anything else is returned trimmed.
+func errorMessage(body []byte) string {
+	var parsed struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		if parsed.Message != "" {
+			return parsed.Message
+		}
+		if parsed.Error != "" {
+			return parsed.Error
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// Permanent reports whether err is an answer of UserService that sending the
+// same request again won't change, a 4xx status other than 429.
+func Permanent(err error) bool {
+	return !temporary(err)
+}
+
+// temporary is false for 4xx responses, a retry won't fix them. Network
+// errors and timeouts of a single attempt are temporary.
+func temporary(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return true
 }
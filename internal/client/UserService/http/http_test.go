@@ -0,0 +1,165 @@
+package httpclient
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient points a client at handler and doesn't wait between retries.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := New(config.UserService{
+		BaseURL:      srv.URL + "/",
+		Timeout:      time.Second,
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	c.jitter = func(d time.Duration) time.Duration { return d }
+	return c
+}
+
+func TestSendRefusal(t *testing.T) {
+	var got Refusal
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/test" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request = %s %s %q, want POST /test with JSON", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	})
+
+	removed := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+	err := c.SendRefusal(context.Background(), test.TrashTest{
+		TestRequest: test.TestRequest{SourceID: 2, TestNumber: 7},
+		Reason:      test.StateEvicted,
+		ArrivalTime: removed.Add(-time.Second),
+		RemovalTime: removed,
+	})
+	if err != nil {
+		t.Fatalf("SendRefusal: %v", err)
+	}
+	if got.TestReq != (test.TestRequest{SourceID: 2, TestNumber: 7}) || got.Status || got.Reason != test.StateEvicted || !got.RemovalTime.Equal(removed) {
+		t.Errorf("UserService got %+v", got)
+	}
+}
+
+func TestSendResult(t *testing.T) {
+	var got Response
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			t.Errorf("path = %s, want /", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	if err := c.SendResult(context.Background(), Response{TestNum: 3, Result: true, Msg: "ok"}); err != nil {
+		t.Fatalf("SendResult: %v", err)
+	}
+	if got != (Response{TestNum: 3, Result: true, Msg: "ok"}) {
+		t.Errorf("UserService got %+v", got)
+	}
+}
+
+func TestRetriesTemporaryErrors(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	if err := c.SendResult(context.Background(), Response{TestNum: 1}); err != nil {
+		t.Fatalf("SendResult: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("UserService was called %d times, want 3", n)
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	err := c.SendResult(context.Background(), Response{TestNum: 1})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("SendResult = %v, want a 429 StatusError", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("UserService was called %d times, want 3", n)
+	}
+}
+
+func TestClientErrorIsNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "unknown source", "status": "error"}`))
+	})
+
+	err := c.SendRefusal(context.Background(), test.TrashTest{TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest || statusErr.Message != "unknown source" {
+		t.Fatalf("SendRefusal = %v, want a 400 StatusError with the body message", err)
+	}
+	if !Permanent(err) {
+		t.Errorf("Permanent(%v) = false, want true", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("UserService was called %d times, want 1", n)
+	}
+}
+
+func TestCancelledContextStopsRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		cancel()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	c.maxRetries = 10
+
+	if err := c.SendResult(ctx, Response{TestNum: 1}); err == nil {
+		t.Fatalf("SendResult with a cancelled context succeeded")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("UserService was called %d times, want 1", n)
+	}
+}
+
+func TestUnreachableIsNotPermanent(t *testing.T) {
+	c := New(config.UserService{BaseURL: "http://127.0.0.1:1", Timeout: time.Second})
+
+	err := c.SendResult(context.Background(), Response{TestNum: 1})
+	if err == nil {
+		t.Fatalf("SendResult to a closed port succeeded")
+	}
+	if Permanent(err) {
+		t.Errorf("Permanent(%v) = true, want false", err)
+	}
+}
+
+func TestHalfJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := halfJitter(time.Second); d < 500*time.Millisecond || d >= time.Second {
+			t.Fatalf("halfJitter(1s) = %v, want within [500ms, 1s)", d)
+		}
+	}
+}
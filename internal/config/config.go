@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
@@ -10,17 +11,32 @@ import (
 
 type Config struct {
 	Env               string `yaml:"env"`
+	StorageConfig     `yaml:"storage"`
 	PostgresConfig    `yaml:"postgres"`
 	HTTPServer        `yaml:"http_server"`
 	GRPCClient        `yaml:"grpc_client"`
+	GRPCServer        `yaml:"grpc_server"`
 	KafkaProducer     `yaml:"kafka_producer"`
+	KafkaConsumer     `yaml:"kafka_consumer"`
 	CycleBufferConfig `yaml:"cycle_buffer"`
+	DispatchConfig    `yaml:"dispatch"`
+	StatsConfig       `yaml:"stats"`
+	OutboxConfig      `yaml:"outbox"`
+	UserService       `yaml:"user_service"`
+	RefusalConfig     `yaml:"refusals"`
+	ResultConfig      `yaml:"results"`
+	Sources           []Source `yaml:"sources"`
+	UnknownSources    `yaml:"unknown_sources"`
+	Admission         `yaml:"admission"`
+	Buffers           []Buffer `yaml:"buffers"`
+	Routes            []Route  `yaml:"routes"`
 }
 
 type HTTPServer struct {
-	Address     string        `yaml:"address"`
-	Timeout     time.Duration `yaml:"timeout"`
-	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	Address         string        `yaml:"address"`
+	Timeout         time.Duration `yaml:"timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"10s"`
 }
 
 type GRPCClient struct {
@@ -28,22 +44,172 @@ type GRPCClient struct {
 	Timeout time.Duration `yaml:"timeout"`
 }
 
+// GRPCServer serves the Dispatcher gRPC API. It is off when Address is empty.
+type GRPCServer struct {
+	Address string `yaml:"address"`
+}
+
 type KafkaProducer struct {
-	Broker string `yaml:"broker"`
-	Topic  string `yaml:"topic"`
+	Broker       string        `yaml:"broker"`
+	Topic        string        `yaml:"topic"`
+	FlushTimeout time.Duration `yaml:"flush_timeout" env-default:"5s"`
+	EventsTopic  string        `yaml:"events_topic" env-default:"dispatcher-events"`
+	// DisableLegacyData stops publishing the old {availableSpace, maxSize}
+	// message to Topic. It is a negative flag because cleanenv would turn a
+	// false read from the file back into a true default.
+	DisableLegacyData bool `yaml:"disable_legacy_data"`
+	// SpoolSize bounds the messages kept for retry while the broker is down.
+	SpoolSize     int           `yaml:"spool_size" env-default:"1000"`
+	MaxRetries    int           `yaml:"max_retries" env-default:"5"`
+	RetryInterval time.Duration `yaml:"retry_interval" env-default:"1s"`
+}
+
+// KafkaConsumer reads test requests from Topic when Enabled, in addition to POST /test.
+type KafkaConsumer struct {
+	Enabled      bool          `yaml:"enabled" env-default:"false"`
+	Broker       string        `yaml:"broker"`
+	Topic        string        `yaml:"topic" env-default:"tests"`
+	GroupID      string        `yaml:"group_id" env-default:"dispatcher"`
+	PollTimeout  time.Duration `yaml:"poll_timeout" env-default:"100ms"`
+	RetryBackoff time.Duration `yaml:"retry_backoff" env-default:"1s"`
+}
+
+type StorageConfig struct {
+	Driver string `yaml:"driver" env-default:"postgres"`
 }
 
+// PostgresConfig is the connection to the Postgres storage. AutoMigrate
+// applies pending migrations on startup; without it the schema is upgraded
+// with the migrate subcommand.
 type PostgresConfig struct {
-	Host     string `yaml:"host"`
-	Port     string `yaml:"port"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	DBName   string `yaml:"db_name"`
-	SSLMode  string `yaml:"ssl_mode"`
+	Host        string `yaml:"host"`
+	Port        string `yaml:"port"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	DBName      string `yaml:"db_name"`
+	SSLMode     string `yaml:"ssl_mode"`
+	AutoMigrate bool   `yaml:"auto_migrate"`
 }
 
+// CycleBufferConfig is the buffer used when no named Buffers are configured.
+// With Buffers MaxSize is best left out, see CheckBuffers.
 type CycleBufferConfig struct {
-	MaxSize int64 `yaml:"max_size"`
+	MaxSize        int64  `yaml:"max_size"`
+	EvictionPolicy string `yaml:"eviction_policy" env-default:"source_order"`
+}
+
+// Buffer is a named circular buffer with its own size and eviction policy.
+// Priority and Weight are used by DispatchConfig.BufferPolicy: lower
+// priorities are drained first, weights share the devices under "weighted",
+// 0 counts as 1.
+type Buffer struct {
+	Name           string `yaml:"name"`
+	MaxSize        int64  `yaml:"max_size"`
+	EvictionPolicy string `yaml:"eviction_policy"`
+	Priority       int    `yaml:"priority"`
+	Weight         int    `yaml:"weight"`
+}
+
+// Route sends the tests of Sources and of the sources in the Priorities
+// classes to Buffer. Routes are tried in order, a test no route matches goes
+// to the first buffer.
+type Route struct {
+	Buffer     string `yaml:"buffer"`
+	Sources    []uint `yaml:"sources"`
+	Priorities []int  `yaml:"priorities"`
+}
+
+type DispatchConfig struct {
+	RequestPolicy string        `yaml:"request_policy" env-default:"priority"`
+	DevicePolicy  string        `yaml:"device_policy" env-default:"first_free"`
+	BufferPolicy  string        `yaml:"buffer_policy" env-default:"strict_priority"`
+	PollInterval  time.Duration `yaml:"poll_interval" env-default:"1s"`
+	MaxAttempts   int           `yaml:"max_attempts" env-default:"3"`
+	RetryBackoff  time.Duration `yaml:"retry_backoff" env-default:"500ms"`
+	MaxBackoff    time.Duration `yaml:"max_backoff" env-default:"10s"`
+	BusyGrace     time.Duration `yaml:"busy_grace" env-default:"2s"`
+}
+
+type StatsConfig struct {
+	Topic    string        `yaml:"topic" env-default:"stats"`
+	Interval time.Duration `yaml:"interval" env-default:"10s"`
+}
+
+// OutboxConfig is used with the Postgres storage only. Request events are
+// written to the outbox table in the transaction that changes the buffer and
+// relayed to KafkaProducer.EventsTopic from there. With Disabled they are
+// published directly, like with the in-memory storage.
+type OutboxConfig struct {
+	Disabled  bool          `yaml:"disabled"`
+	BatchSize int           `yaml:"batch_size" env-default:"100"`
+	Interval  time.Duration `yaml:"interval" env-default:"1s"`
+}
+
+// UserService receives test results and refusals. Timeout is per attempt,
+// failed requests are retried up to MaxRetries times starting with
+// RetryBackoff and doubling it.
+type UserService struct {
+	BaseURL      string        `yaml:"base_url" env-default:"http://localhost:8081"`
+	Timeout      time.Duration `yaml:"timeout" env-default:"5s"`
+	MaxRetries   int           `yaml:"max_retries" env-default:"3"`
+	RetryBackoff time.Duration `yaml:"retry_backoff" env-default:"200ms"`
+}
+
+// RefusalConfig controls how sources learn about their evicted and rejected
+// tests. Undelivered refusals are retried every Interval.
+type RefusalConfig struct {
+	BatchSize int           `yaml:"batch_size" env-default:"100"`
+	Interval  time.Duration `yaml:"interval" env-default:"1s"`
+	Timeout   time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// ResultConfig controls how the results devices report reach UserService.
+// Undelivered results are retried every Interval.
+type ResultConfig struct {
+	BatchSize int           `yaml:"batch_size" env-default:"100"`
+	Interval  time.Duration `yaml:"interval" env-default:"1s"`
+	Timeout   time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// Source configures one test source. Lower priority classes are dispatched
+// first and evicted last. Weight shares the devices between the sources of a
+// class under the weighted request policy, 0 counts as 1. MaxShare caps the
+// part of the buffer the source may hold, 0 means no cap.
+//
+// The rest is enforced by admission control, a test over a limit is refused
+// with 429. MaxBuffered caps how many tests of the source may be buffered
+// when it submits another one. Rate is how many tests per second the source
+// may submit, with bursts of up to Burst tests; 0 means no limit, a Burst of
+// 0 is Rate rounded up.
+type Source struct {
+	ID          uint    `yaml:"id"`
+	Priority    int     `yaml:"priority"`
+	Weight      int     `yaml:"weight"`
+	MaxShare    float64 `yaml:"max_share"`
+	MaxBuffered int     `yaml:"max_buffered"`
+	Rate        float64 `yaml:"rate"`
+	Burst       int     `yaml:"burst"`
+}
+
+// UnknownSources is the policy for sources missing from Sources: "accept"
+// treats them as a source with the settings below, "reject" refuses their
+// tests with 403.
+type UnknownSources struct {
+	Policy      string  `yaml:"policy" env-default:"accept"`
+	Priority    int     `yaml:"priority"`
+	Weight      int     `yaml:"weight" env-default:"1"`
+	MaxShare    float64 `yaml:"max_share"`
+	MaxBuffered int     `yaml:"max_buffered"`
+	Rate        float64 `yaml:"rate"`
+	Burst       int     `yaml:"burst"`
+}
+
+// Admission limits the tests the dispatcher holds, buffered or running on a
+// device until they complete, to MaxInFlight, 0 means no limit. RetryAfter is
+// what a refused caller is told to wait when no better estimate is known.
+type Admission struct {
+	MaxInFlight int           `yaml:"max_in_flight"`
+	RetryAfter  time.Duration `yaml:"retry_after" env-default:"1s"`
 }
 
 func MustLoad() *Config {
@@ -57,13 +223,42 @@ func MustLoad() *Config {
 		panic("config path doesn't exist: " + configPath)
 	}
 
+	cfg, err := Load(configPath)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return cfg
+}
+
+// Load reads the config file at path, environment variables override it.
+func Load(path string) (*Config, error) {
 	var cfg Config
 
-	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic("failed to read config: " + err.Error())
+	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := cfg.CheckBuffers(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
-	return &cfg
+	return &cfg, nil
+}
+
+// CheckBuffers fails when both cycle_buffer.max_size and the named buffers
+// are set and the size of the buffer called "default" is not the same, the
+// named buffers would silently win otherwise.
+func (cfg *Config) CheckBuffers() error {
+	if len(cfg.Buffers) == 0 || cfg.CycleBufferConfig.MaxSize == 0 {
+		return nil
+	}
+
+	for _, b := range cfg.Buffers {
+		if b.Name == "default" && b.MaxSize == cfg.CycleBufferConfig.MaxSize {
+			return nil
+		}
+	}
+	return fmt.Errorf("cycle_buffer.max_size %d does not match the buffer \"default\", leave it out when buffers are configured", cfg.CycleBufferConfig.MaxSize)
 }
 
 func fetchConfigPath() string {
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func load(t *testing.T, yaml string) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return cfg
+}
+
+func TestLegacyData(t *testing.T) {
+	if cfg := load(t, "kafka_producer:\n  topic: analytics\n"); cfg.KafkaProducer.DisableLegacyData {
+		t.Errorf("legacy data is disabled by default")
+	}
+	if cfg := load(t, "kafka_producer:\n  disable_legacy_data: false\n"); cfg.KafkaProducer.DisableLegacyData {
+		t.Errorf("disable_legacy_data: false loaded as true")
+	}
+	if cfg := load(t, "kafka_producer:\n  disable_legacy_data: true\n"); !cfg.KafkaProducer.DisableLegacyData {
+		t.Errorf("disable_legacy_data: true loaded as false")
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	if cfg := load(t, "outbox:\n  batch_size: 10\n"); cfg.OutboxConfig.Disabled {
+		t.Errorf("outbox is disabled by default")
+	}
+	if cfg := load(t, "outbox:\n  disabled: true\n"); !cfg.OutboxConfig.Disabled {
+		t.Errorf("outbox disabled: true loaded as false")
+	}
+	if cfg := load(t, "outbox:\n  disabled: false\n"); cfg.OutboxConfig.Disabled {
+		t.Errorf("outbox disabled: false loaded as true")
+	}
+}
@@ -0,0 +1,103 @@
+package dispatcher
+
+import (
+	"Dispatcher/internal/buffers"
+	"fmt"
+	"sync"
+)
+
+const (
+	BufferStrictPriority = "strict_priority"
+	BufferWeighted       = "weighted"
+)
+
+// BufferSelector decides which named buffer the next test is taken from.
+type BufferSelector interface {
+	// Next returns one of ready, the buffers that hold ready tests. ok is
+	// false when ready is empty.
+	Next(ready []string) (next string, ok bool)
+}
+
+// NewBufferSelector returns the selector registered under name. Empty name
+// means BufferStrictPriority. Buffer priorities and weights come from layout.
+func NewBufferSelector(name string, layout *buffers.Layout) (BufferSelector, error) {
+	switch name {
+	case "", BufferStrictPriority:
+		return strictPriority{layout}, nil
+	case BufferWeighted:
+		return &weightedBuffers{layout: layout, current: make(map[string]int)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown buffer selection policy: %q", name)
+}
+
+// strictPriority drains the buffer with the lowest priority first, a buffer
+// is only served when every buffer before it has no ready test. Buffers of
+// the same priority go in the configured order.
+type strictPriority struct {
+	layout *buffers.Layout
+}
+
+func (p strictPriority) Next(ready []string) (string, bool) {
+	if len(ready) == 0 {
+		return "", false
+	}
+
+	next, best := "", buffers.Buffer{}
+	for _, b := range p.layout.Buffers() {
+		if !contains(ready, b.Name) {
+			continue
+		}
+		if next == "" || b.Priority < best.Priority {
+			next, best = b.Name, b
+		}
+	}
+	return next, next != ""
+}
+
+// weightedBuffers shares the devices between the buffers with ready tests by
+// weight with smooth weighted round robin.
+type weightedBuffers struct {
+	layout  *buffers.Layout
+	mu      sync.Mutex
+	current map[string]int
+}
+
+func (w *weightedBuffers) Next(ready []string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// buffers that ran dry start over when they have ready tests again
+	for name := range w.current {
+		if !contains(ready, name) {
+			delete(w.current, name)
+		}
+	}
+
+	total := 0
+	pick := ""
+	for _, b := range w.layout.Buffers() {
+		if !contains(ready, b.Name) {
+			continue
+		}
+		total += b.Weight
+		w.current[b.Name] += b.Weight
+		if pick == "" || w.current[b.Name] > w.current[pick] {
+			pick = b.Name
+		}
+	}
+	if pick == "" {
+		return "", false
+	}
+	w.current[pick] -= total
+	return pick, true
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package dispatcher
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+)
+
+const (
+	DeviceFirstFree  = "first_free"
+	DeviceRoundRobin = "round_robin"
+	DeviceLRU        = "lru"
+	DeviceRandom     = "random"
+)
+
+// DeviceSelector decides which of the free devices gets the next test.
+type DeviceSelector interface {
+	// Next returns the device to use. ok is false when devices is empty.
+	Next(devices []*device.DeviceResponse) (next *device.DeviceResponse, ok bool)
+}
+
+// NewDeviceSelector returns the selector registered under name. Empty name
+// means DeviceFirstFree.
+func NewDeviceSelector(name string) (DeviceSelector, error) {
+	switch name {
+	case "", DeviceFirstFree:
+		return firstFree{}, nil
+	case DeviceRoundRobin:
+		return &roundRobin{}, nil
+	case DeviceLRU:
+		return &lru{used: make(map[int32]uint64)}, nil
+	case DeviceRandom:
+		return NewRandomDevice(rand.New(rand.NewSource(time.Now().UnixNano()))), nil
+	}
+
+	return nil, fmt.Errorf("unknown device selection policy: %q", name)
+}
+
+// firstFree takes devices in the order DeviceService lists them.
+type firstFree struct{}
+
+func (firstFree) Next(devices []*device.DeviceResponse) (*device.DeviceResponse, bool) {
+	if len(devices) == 0 {
+		return nil, false
+	}
+	return devices[0], true
+}
+
+// roundRobin keeps a pointer to the last used device id and moves it to the
+// next free device with a bigger id, wrapping around to the smallest one.
+type roundRobin struct {
+	mu      sync.Mutex
+	last    int32
+	started bool
+}
+
+func (r *roundRobin) Next(devices []*device.DeviceResponse) (*device.DeviceResponse, bool) {
+	if len(devices) == 0 {
+		return nil, false
+	}
+
+	sorted := make([]*device.DeviceResponse, len(devices))
+	copy(sorted, devices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].DeviceId < sorted[j].DeviceId
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next := sorted[0]
+	if r.started {
+		for _, d := range sorted {
+			if d.DeviceId > r.last {
+				next = d
+				break
+			}
+		}
+	}
+
+	r.last = next.DeviceId
+	r.started = true
+
+	return next, true
+}
+
+// lru gives the test to the free device that was used least recently.
+// Devices that never got a test come first, in id order.
+type lru struct {
+	mu   sync.Mutex
+	seq  uint64
+	used map[int32]uint64
+}
+
+func (l *lru) Next(devices []*device.DeviceResponse) (*device.DeviceResponse, bool) {
+	if len(devices) == 0 {
+		return nil, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	next := devices[0]
+	for _, d := range devices[1:] {
+		du, nu := l.used[d.DeviceId], l.used[next.DeviceId]
+		if du < nu || (du == nu && d.DeviceId < next.DeviceId) {
+			next = d
+		}
+	}
+
+	l.seq++
+	l.used[next.DeviceId] = l.seq
+
+	return next, true
+}
+
+type randomDevice struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandomDevice returns a selector that picks a uniformly chosen free device
+// using rnd.
+func NewRandomDevice(rnd *rand.Rand) DeviceSelector {
+	return &randomDevice{rnd: rnd}
+}
+
+func (r *randomDevice) Next(devices []*device.DeviceResponse) (*device.DeviceResponse, bool) {
+	if len(devices) == 0 {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	i := r.rnd.Intn(len(devices))
+	r.mu.Unlock()
+
+	return devices[i], true
+}
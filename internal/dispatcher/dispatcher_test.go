@@ -0,0 +1,258 @@
+package dispatcher
+
+import (
+	"Dispatcher/internal/buffers"
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"Dispatcher/internal/storage/storagetest"
+	"math/rand"
+	"slices"
+	"testing"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+)
+
+func drain(t *testing.T, s RequestSelector, in []test.BufferedTest) []int64 {
+	t.Helper()
+
+	var order []int64
+	for {
+		next, ok := s.Next(in)
+		if !ok {
+			return order
+		}
+		order = append(order, next.Pos)
+		in = withoutTest(in, next.Pos)
+	}
+}
+
+func TestRequestSelectors(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []int64
+	}{
+		{policy: RequestPriority, want: []int64{3, 1, 0, 2, 4}},
+		{policy: RequestFIFO, want: []int64{2, 4, 0, 1, 3}},
+		{policy: RequestLIFO, want: []int64{3, 1, 0, 4, 2}},
+		{policy: RequestPacket, want: []int64{3, 1, 0, 2, 4}},
+		{policy: RequestWeighted, want: []int64{3, 0, 2, 4, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s, err := NewRequestSelector(tt.policy, nil)
+			if err != nil {
+				t.Fatalf("NewRequestSelector(%q): %v", tt.policy, err)
+			}
+
+			got := drain(t, s, storagetest.Buffered())
+			if len(got) != len(tt.want) {
+				t.Fatalf("order = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("order = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketKeepsSourceUntilDrained(t *testing.T) {
+	s, _ := NewRequestSelector(RequestPacket, nil)
+	in := []test.BufferedTest{
+		{Pos: 0, TestRequest: test.TestRequest{SourceID: 2, TestNumber: 1}},
+		{Pos: 1, TestRequest: test.TestRequest{SourceID: 2, TestNumber: 2}},
+	}
+
+	if next, _ := s.Next(in); next.Pos != 0 {
+		t.Fatalf("first pick pos = %d, want 0", next.Pos)
+	}
+
+	// A higher priority source arrives while source 2 still has tests buffered.
+	in = []test.BufferedTest{
+		in[1],
+		{Pos: 2, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}},
+	}
+	if next, _ := s.Next(in); next.Pos != 1 {
+		t.Fatalf("packet switched source early, pos = %d, want 1", next.Pos)
+	}
+	if next, _ := s.Next(in[1:]); next.Pos != 2 {
+		t.Fatalf("packet did not move to the next source, pos = %d, want 2", next.Pos)
+	}
+}
+
+func TestSelectorsUseSourcePriority(t *testing.T) {
+	table, err := sources.New([]config.Source{{ID: 2, Priority: 0}, {ID: 1, Priority: 1}}, config.UnknownSources{})
+	if err != nil {
+		t.Fatalf("sources.New: %v", err)
+	}
+
+	for _, policy := range []string{RequestPriority, RequestPacket, RequestWeighted} {
+		s, _ := NewRequestSelector(policy, table)
+		got := drain(t, s, storagetest.Buffered())
+		if want := []int64{0, 2, 4, 3, 1}; !slices.Equal(got, want) {
+			t.Errorf("%s order = %v, want %v", policy, got, want)
+		}
+	}
+}
+
+func TestWeightedSharesClassByWeight(t *testing.T) {
+	table, err := sources.New([]config.Source{{ID: 1, Weight: 3}, {ID: 2, Weight: 1}, {ID: 3, Priority: 1}}, config.UnknownSources{})
+	if err != nil {
+		t.Fatalf("sources.New: %v", err)
+	}
+	s, _ := NewRequestSelector(RequestWeighted, table)
+
+	var in []test.BufferedTest
+	for i := 0; i < 4; i++ {
+		for _, src := range []uint{1, 2, 3} {
+			in = append(in, test.BufferedTest{Pos: int64(len(in)), TestRequest: test.TestRequest{SourceID: src, TestNumber: uint(i + 1)}})
+		}
+	}
+
+	picked := make(map[uint]int)
+	for i := 0; i < 4; i++ {
+		next, _ := s.Next(in)
+		picked[next.SourceID]++
+		in = withoutTest(in, next.Pos)
+	}
+
+	if picked[1] != 3 || picked[2] != 1 || picked[3] != 0 {
+		t.Errorf("picked per source = %v, want 3 from source 1 and 1 from source 2", picked)
+	}
+}
+
+func TestBufferSelectors(t *testing.T) {
+	layout, err := buffers.New(&config.Config{Buffers: []config.Buffer{
+		{Name: "a", MaxSize: 1, Priority: 1, Weight: 2},
+		{Name: "b", MaxSize: 1, Weight: 1},
+		{Name: "c", MaxSize: 1, Priority: 1},
+	}}, nil)
+	if err != nil {
+		t.Fatalf("buffers.New: %v", err)
+	}
+
+	tests := []struct {
+		policy string
+		ready  []string
+		want   []string
+	}{
+		{policy: BufferStrictPriority, ready: []string{"a", "b", "c"}, want: []string{"b", "b", "b"}},
+		{policy: "", ready: []string{"c", "a"}, want: []string{"a", "a", "a"}},
+		{policy: BufferWeighted, ready: []string{"a", "b", "c"}, want: []string{"a", "b", "c", "a", "a", "b", "c", "a"}},
+		{policy: BufferWeighted, ready: []string{"b", "c"}, want: []string{"b", "c", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		s, err := NewBufferSelector(tt.policy, layout)
+		if err != nil {
+			t.Fatalf("NewBufferSelector(%q): %v", tt.policy, err)
+		}
+
+		var got []string
+		for range tt.want {
+			next, ok := s.Next(tt.ready)
+			if !ok {
+				t.Fatalf("%q: Next(%v) found nothing", tt.policy, tt.ready)
+			}
+			got = append(got, next)
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q over %v = %v, want %v", tt.policy, tt.ready, got, tt.want)
+				break
+			}
+		}
+	}
+
+	s, _ := NewBufferSelector(BufferWeighted, layout)
+	if _, ok := s.Next(nil); ok {
+		t.Errorf("weighted Next on no ready buffers returned one")
+	}
+}
+
+func devices(ids ...int32) []*device.DeviceResponse {
+	list := make([]*device.DeviceResponse, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, &device.DeviceResponse{DeviceId: id})
+	}
+	return list
+}
+
+func TestDeviceSelectors(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []int32
+	}{
+		{policy: DeviceFirstFree, want: []int32{3, 3, 3, 3}},
+		{policy: DeviceRoundRobin, want: []int32{1, 2, 3, 1}},
+		{policy: DeviceLRU, want: []int32{1, 2, 3, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s, err := NewDeviceSelector(tt.policy)
+			if err != nil {
+				t.Fatalf("NewDeviceSelector(%q): %v", tt.policy, err)
+			}
+
+			for i, want := range tt.want {
+				got, ok := s.Next(devices(3, 1, 2))
+				if !ok {
+					t.Fatalf("step %d: no device selected", i)
+				}
+				if got.DeviceId != want {
+					t.Fatalf("step %d: device = %d, want %d", i, got.DeviceId, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundRobinSkipsBusyDevices(t *testing.T) {
+	s, _ := NewDeviceSelector(DeviceRoundRobin)
+
+	if got, _ := s.Next(devices(1, 2, 3)); got.DeviceId != 1 {
+		t.Fatalf("device = %d, want 1", got.DeviceId)
+	}
+	if got, _ := s.Next(devices(1, 3)); got.DeviceId != 3 {
+		t.Fatalf("device = %d, want 3", got.DeviceId)
+	}
+	if got, _ := s.Next(devices(1, 2)); got.DeviceId != 1 {
+		t.Fatalf("device = %d, want 1 after wraparound", got.DeviceId)
+	}
+}
+
+func TestRandomDevice(t *testing.T) {
+	s := NewRandomDevice(rand.New(rand.NewSource(1)))
+
+	seen := make(map[int32]bool)
+	for i := 0; i < 100; i++ {
+		got, ok := s.Next(devices(1, 2, 3))
+		if !ok {
+			t.Fatalf("no device selected")
+		}
+		seen[got.DeviceId] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("random selector used %d devices, want 3", len(seen))
+	}
+
+	if _, ok := s.Next(nil); ok {
+		t.Errorf("random selector picked a device from an empty list")
+	}
+}
+
+func TestUnknownSelectors(t *testing.T) {
+	if _, err := NewRequestSelector("lottery", nil); err == nil {
+		t.Errorf("expected an error for an unknown request policy")
+	}
+	if _, err := NewDeviceSelector("lottery"); err == nil {
+		t.Errorf("expected an error for an unknown device policy")
+	}
+	if _, err := NewBufferSelector("lottery", nil); err == nil {
+		t.Errorf("expected an error for an unknown buffer policy")
+	}
+}
@@ -0,0 +1,217 @@
+package dispatcher
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"sort"
+	"sync"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+)
+
+// DeviceState is what the dispatcher knows about one device.
+type DeviceState struct {
+	ID        int32             `json:"id"`
+	Busy      bool              `json:"busy"`
+	BusySince time.Time         `json:"busy_since"`
+	Current   *test.TestRequest `json:"current,omitempty"`
+	Served    int64             `json:"served"`
+	BusyTime  time.Duration     `json:"busy_time"`
+}
+
+// Registry tracks which devices the dispatcher handed work to. DeviceService
+// may still list a device as free right after it got a test, so a busy device
+// is trusted over GetDeviceList until grace passes.
+type Registry struct {
+	mu      sync.Mutex
+	grace   time.Duration
+	devices map[int32]*DeviceState
+	now     func() time.Time
+
+	observers []DeviceObserver
+}
+
+// DeviceObserver is told whenever a device becomes busy or free. It is called
+// without the registry lock held. For a device that became free Current is
+// the test it was working on.
+type DeviceObserver interface {
+	ObserveDevice(st DeviceState)
+}
+
+func NewRegistry(grace time.Duration) *Registry {
+	return &Registry{
+		grace:   grace,
+		devices: make(map[int32]*DeviceState),
+		now:     time.Now,
+	}
+}
+
+// Free reconciles the registry with the devices DeviceService listed as free
+// and returns those that are free in the dispatcher's view as well. A device
+// that stayed busy longer than grace while DeviceService reports it free is
+// considered finished. When it finished is unknown, so it counts as busy for
+// grace only.
+func (r *Registry) Free(listed []*device.DeviceResponse) []*device.DeviceResponse {
+	var changed []DeviceState
+	defer func() { r.notify(changed...) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	free := make([]*device.DeviceResponse, 0, len(listed))
+	for _, d := range listed {
+		st, ok := r.devices[d.DeviceId]
+		if !ok {
+			st = &DeviceState{ID: d.DeviceId}
+			r.devices[d.DeviceId] = st
+		}
+
+		if st.Busy {
+			if now.Sub(st.BusySince) < r.grace {
+				continue
+			}
+			changed = append(changed, r.release(st, st.BusySince.Add(r.grace), true))
+		}
+
+		free = append(free, d)
+	}
+
+	return free
+}
+
+// Acquire marks the device busy with req. It returns false when the device
+// is already busy.
+func (r *Registry) Acquire(id int32, req test.TestRequest) bool {
+	r.mu.Lock()
+
+	st, ok := r.devices[id]
+	if !ok {
+		st = &DeviceState{ID: id}
+		r.devices[id] = st
+	}
+	if st.Busy {
+		r.mu.Unlock()
+		return false
+	}
+
+	st.Busy = true
+	st.BusySince = r.now()
+	st.Current = &req
+	changed := *st
+	r.mu.Unlock()
+
+	r.notify(changed)
+	return true
+}
+
+// Current returns the test the device is busy with.
+func (r *Registry) Current(id int32) (test.TestRequest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st, ok := r.devices[id]
+	if !ok || !st.Busy || st.Current == nil {
+		return test.TestRequest{}, false
+	}
+	return *st.Current, true
+}
+
+// Release frees the device after it finished req. It returns false and
+// leaves the device alone when the device is not busy with req, like for a
+// late or repeated report.
+func (r *Registry) Release(id int32, req test.TestRequest) bool {
+	r.mu.Lock()
+	st, ok := r.devices[id]
+	if !ok || !st.Busy || st.Current == nil || *st.Current != req {
+		r.mu.Unlock()
+		return false
+	}
+	changed := r.release(st, r.now(), true)
+	r.mu.Unlock()
+
+	r.notify(changed)
+	return true
+}
+
+// Abort frees the device when the test never reached it.
+func (r *Registry) Abort(id int32) {
+	r.mu.Lock()
+	st, ok := r.devices[id]
+	if !ok || !st.Busy {
+		r.mu.Unlock()
+		return
+	}
+	changed := r.release(st, r.now(), false)
+	r.mu.Unlock()
+
+	r.notify(changed)
+}
+
+// Busy returns how many devices are busy in the dispatcher's view.
+func (r *Registry) Busy() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, st := range r.devices {
+		if st.Busy {
+			n++
+		}
+	}
+	return n
+}
+
+// Subscribe registers o for every busy/free change afterwards. It has to be
+// called before the registry is used.
+func (r *Registry) Subscribe(o DeviceObserver) {
+	r.observers = append(r.observers, o)
+}
+
+func (r *Registry) notify(changed ...DeviceState) {
+	for _, st := range changed {
+		for _, o := range r.observers {
+			o.ObserveDevice(st)
+		}
+	}
+}
+
+// Snapshot returns the state of every known device ordered by id. Busy time
+// of busy devices includes the current test.
+func (r *Registry) Snapshot() []DeviceState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	states := make([]DeviceState, 0, len(r.devices))
+	for _, st := range r.devices {
+		s := *st
+		if s.Busy {
+			s.BusyTime += now.Sub(s.BusySince)
+		}
+		states = append(states, s)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ID < states[j].ID
+	})
+
+	return states
+}
+
+// release must be called with r.mu held. It returns the new state together
+// with the test the device was working on.
+func (r *Registry) release(st *DeviceState, now time.Time, served bool) DeviceState {
+	current := st.Current
+	if served {
+		st.Served++
+		st.BusyTime += now.Sub(st.BusySince)
+	}
+	st.Busy = false
+	st.BusySince = time.Time{}
+	st.Current = nil
+
+	changed := *st
+	changed.Current = current
+	return changed
+}
@@ -0,0 +1,171 @@
+package dispatcher
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"testing"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestRegistry(grace time.Duration) (*Registry, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r := NewRegistry(grace)
+	r.now = clock.now
+	return r, clock
+}
+
+func deviceIDs(devices []*device.DeviceResponse) []int32 {
+	out := make([]int32, 0, len(devices))
+	for _, d := range devices {
+		out = append(out, d.DeviceId)
+	}
+	return out
+}
+
+func TestRegistryAcquireTwice(t *testing.T) {
+	r, _ := newTestRegistry(time.Second)
+
+	if !r.Acquire(1, test.TestRequest{SourceID: 1, TestNumber: 1}) {
+		t.Fatalf("Acquire on a free device failed")
+	}
+	if r.Acquire(1, test.TestRequest{SourceID: 1, TestNumber: 2}) {
+		t.Fatalf("busy device was acquired twice")
+	}
+
+	r.Abort(1)
+	if !r.Acquire(1, test.TestRequest{SourceID: 1, TestNumber: 2}) {
+		t.Fatalf("Acquire after Abort failed")
+	}
+}
+
+func TestRegistryFreeHidesBusyDevices(t *testing.T) {
+	r, clock := newTestRegistry(time.Second)
+	r.Acquire(1, test.TestRequest{SourceID: 1, TestNumber: 1})
+
+	got := deviceIDs(r.Free(devices(1, 2)))
+	if len(got) != 1 || got[0] != 2 {
+		t.Fatalf("Free = %v, want [2]", got)
+	}
+
+	// DeviceService still lists it after grace, so the test must be done
+	clock.t = clock.t.Add(2 * time.Second)
+	got = deviceIDs(r.Free(devices(1, 2)))
+	if len(got) != 2 {
+		t.Fatalf("Free after grace = %v, want [1 2]", got)
+	}
+
+	// the test may have ended any time after grace, only grace is counted
+	st := r.Snapshot()
+	if st[0].Busy || st[0].Served != 1 || st[0].BusyTime != time.Second {
+		t.Errorf("device 1 = %+v, want free, served once, busy 1s", st[0])
+	}
+}
+
+func TestRegistryAccounting(t *testing.T) {
+	r, clock := newTestRegistry(time.Second)
+
+	r.Acquire(1, test.TestRequest{SourceID: 1, TestNumber: 1})
+	clock.t = clock.t.Add(300 * time.Millisecond)
+	r.Release(1, test.TestRequest{SourceID: 1, TestNumber: 1})
+
+	r.Acquire(1, test.TestRequest{SourceID: 1, TestNumber: 2})
+	clock.t = clock.t.Add(time.Second)
+	r.Abort(1)
+
+	r.Acquire(2, test.TestRequest{SourceID: 2, TestNumber: 1})
+	clock.t = clock.t.Add(100 * time.Millisecond)
+
+	st := r.Snapshot()
+	if len(st) != 2 {
+		t.Fatalf("Snapshot has %d devices, want 2", len(st))
+	}
+	if st[0].ID != 1 || st[0].Busy || st[0].Served != 1 || st[0].BusyTime != 300*time.Millisecond {
+		t.Errorf("device 1 = %+v, want free, served once, busy 300ms", st[0])
+	}
+	if st[1].ID != 2 || !st[1].Busy || st[1].Current == nil || st[1].Current.SourceID != 2 || st[1].BusyTime != 100*time.Millisecond {
+		t.Errorf("device 2 = %+v, want busy with 2/1 for 100ms", st[1])
+	}
+	if got := r.Busy(); got != 1 {
+		t.Errorf("Busy = %d, want 1", got)
+	}
+}
+
+func TestRegistryReleaseChecksTest(t *testing.T) {
+	r, _ := newTestRegistry(time.Second)
+	req := test.TestRequest{SourceID: 1, TestNumber: 1}
+
+	if r.Release(1, req) {
+		t.Errorf("Release of an unknown device succeeded")
+	}
+
+	r.Acquire(1, req)
+	if cur, ok := r.Current(1); !ok || cur != req {
+		t.Errorf("Current = %v, %v, want %v", cur, ok, req)
+	}
+	if r.Release(1, test.TestRequest{SourceID: 1, TestNumber: 2}) {
+		t.Errorf("Release with another test succeeded")
+	}
+	if r.Busy() != 1 {
+		t.Fatalf("device was freed by a report for another test")
+	}
+
+	if !r.Release(1, req) {
+		t.Errorf("Release with the current test failed")
+	}
+	if r.Release(1, req) {
+		t.Errorf("repeated Release succeeded")
+	}
+	if _, ok := r.Current(1); ok {
+		t.Errorf("a free device has a current test")
+	}
+	if st := r.Snapshot(); st[0].Busy || st[0].Served != 1 {
+		t.Errorf("device 1 = %+v, want free, served once", st[0])
+	}
+}
+
+func TestWorkerSkipsBusyDevices(t *testing.T) {
+	buf := newFakeBuffer(
+		test.BufferedTest{Pos: 0, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}},
+		test.BufferedTest{Pos: 1, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 2}},
+	)
+	client := &fakeDevices{free: []int32{7, 8}}
+	w := newTestWorker(buf, client, time.Hour)
+	w.registry, _ = newTestRegistry(time.Hour)
+	w.registry.Acquire(7, test.TestRequest{SourceID: 9, TestNumber: 9})
+
+	w.dispatch()
+
+	if len(client.sent) != 1 || client.sent[0].device != 8 {
+		t.Fatalf("sent = %v, want one test to device 8", client.sent)
+	}
+	if buf.len() != 1 {
+		t.Errorf("buffer holds %d tests, want 1", buf.len())
+	}
+}
+
+// noDevice is a device selector that never finds a device.
+type noDevice struct{}
+
+func (noDevice) Next([]*device.DeviceResponse) (*device.DeviceResponse, bool) { return nil, false }
+
+func TestWorkerWithoutSelectedDevice(t *testing.T) {
+	buf := newFakeBuffer(test.BufferedTest{Pos: 0, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}})
+	client := &fakeDevices{free: []int32{7}}
+	w := newTestWorker(buf, client, time.Hour)
+	w.devices = noDevice{}
+
+	w.dispatch()
+
+	if len(client.sent) != 0 || buf.len() != 1 {
+		t.Errorf("sent = %v with %d tests left, want the test kept in the buffer", client.sent, buf.len())
+	}
+}
@@ -0,0 +1,188 @@
+package dispatcher
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"fmt"
+	"sync"
+)
+
+const (
+	RequestPriority = "priority"
+	RequestFIFO     = "fifo"
+	RequestLIFO     = "lifo"
+	RequestPacket   = "packet"
+	RequestWeighted = "weighted"
+)
+
+// RequestSelector decides which buffered test is sent to a device next.
+type RequestSelector interface {
+	// Next returns the test to dispatch. ok is false when buffered is empty.
+	Next(buffered []test.BufferedTest) (next test.BufferedTest, ok bool)
+}
+
+// NewRequestSelector returns the selector registered under name. Empty name
+// means RequestPriority. Source priorities come from table.
+func NewRequestSelector(name string, table *sources.Table) (RequestSelector, error) {
+	switch name {
+	case "", RequestPriority:
+		return priority{table}, nil
+	case RequestFIFO:
+		return fifo{}, nil
+	case RequestLIFO:
+		return lifo{}, nil
+	case RequestPacket:
+		return &packet{table: table}, nil
+	case RequestWeighted:
+		return &weighted{table: table, current: make(map[uint]int)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown request selection policy: %q", name)
+}
+
+// priority serves the highest priority source first, then the lowest
+// request number of that source.
+type priority struct {
+	table *sources.Table
+}
+
+func (p priority) Next(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	return first(buffered, bySource(p.table))
+}
+
+// fifo serves tests in the order they arrived in the buffer.
+type fifo struct{}
+
+func (fifo) Next(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	return first(buffered, func(a, b test.BufferedTest) bool {
+		if !a.ArrivalTime.Equal(b.ArrivalTime) {
+			return a.ArrivalTime.Before(b.ArrivalTime)
+		}
+		return a.Pos < b.Pos
+	})
+}
+
+// lifo serves the most recently buffered test first.
+type lifo struct{}
+
+func (lifo) Next(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	return first(buffered, func(a, b test.BufferedTest) bool {
+		if !a.ArrivalTime.Equal(b.ArrivalTime) {
+			return a.ArrivalTime.After(b.ArrivalTime)
+		}
+		return a.Pos > b.Pos
+	})
+}
+
+// packet picks the highest priority source present in the buffer and drains
+// all of its tests before choosing a source again.
+type packet struct {
+	table  *sources.Table
+	mu     sync.Mutex
+	source uint
+	active bool
+}
+
+func (p *packet) Next(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active {
+		var batch []test.BufferedTest
+		for _, t := range buffered {
+			if t.SourceID == p.source {
+				batch = append(batch, t)
+			}
+		}
+		if len(batch) > 0 {
+			return first(batch, bySource(p.table))
+		}
+	}
+
+	next, ok := first(buffered, bySource(p.table))
+	p.active = ok
+	p.source = next.SourceID
+
+	return next, ok
+}
+
+// weighted serves the highest priority class present in the buffer and
+// shares it between its sources by weight with smooth weighted round robin.
+// Within a source the lowest request number goes first.
+type weighted struct {
+	table   *sources.Table
+	mu      sync.Mutex
+	current map[uint]int
+}
+
+func (w *weighted) Next(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	top, ok := first(buffered, bySource(w.table))
+	if !ok {
+		return top, false
+	}
+	class := w.table.Get(top.SourceID).Priority
+
+	var ids []uint
+	seen := make(map[uint]bool)
+	for _, t := range buffered {
+		if !seen[t.SourceID] && w.table.Get(t.SourceID).Priority == class {
+			seen[t.SourceID] = true
+			ids = append(ids, t.SourceID)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// sources that left the class start over when they come back
+	for id := range w.current {
+		if !seen[id] {
+			delete(w.current, id)
+		}
+	}
+
+	total := 0
+	var pick uint
+	for i, id := range ids {
+		weight := w.table.Get(id).Weight
+		total += weight
+		w.current[id] += weight
+		if i == 0 || w.current[id] > w.current[pick] || (w.current[id] == w.current[pick] && id < pick) {
+			pick = id
+		}
+	}
+	w.current[pick] -= total
+
+	var batch []test.BufferedTest
+	for _, t := range buffered {
+		if t.SourceID == pick {
+			batch = append(batch, t)
+		}
+	}
+	return first(batch, bySource(w.table))
+}
+
+// bySource orders tests by source priority, then by request number.
+func bySource(table *sources.Table) func(a, b test.BufferedTest) bool {
+	return func(a, b test.BufferedTest) bool {
+		if a.SourceID != b.SourceID {
+			return table.Less(a.SourceID, b.SourceID)
+		}
+		return a.TestNumber < b.TestNumber
+	}
+}
+
+func first(buffered []test.BufferedTest, less func(a, b test.BufferedTest) bool) (test.BufferedTest, bool) {
+	if len(buffered) == 0 {
+		return test.BufferedTest{}, false
+	}
+
+	next := buffered[0]
+	for _, t := range buffered[1:] {
+		if less(t, next) {
+			next = t
+		}
+	}
+
+	return next, true
+}
@@ -0,0 +1,298 @@
+package dispatcher
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+)
+
+// Buffer is the part of the circular buffer the worker drains.
+type Buffer interface {
+	ListTests() ([]test.BufferedTest, error)
+	MarkInFlight(pos int64) error
+	RetryTest(pos int64, backoff time.Duration, maxAttempts int, reason string) (bool, error)
+	DispatchTest(pos int64, deviceID int32) error
+}
+
+// Retry describes what happens to a test whose send failed.
+type Retry struct {
+	// MaxAttempts is how many failed sends move the test to dead letters.
+	MaxAttempts int
+	// Backoff is the delay after the first failure, it doubles each attempt.
+	Backoff time.Duration
+	// MaxBackoff caps the delay.
+	MaxBackoff time.Duration
+}
+
+// delay returns the backoff after the given number of failed attempts.
+func (r Retry) delay(attempts int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempts && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+// Worker moves buffered tests to free devices. It wakes up every poll
+// interval and whenever Notify is called.
+type Worker struct {
+	log      *slog.Logger
+	buffer   Buffer
+	client   test.DeviceDispatcher
+	requests RequestSelector
+	buffers  BufferSelector
+	devices  DeviceSelector
+	registry *Registry
+	interval time.Duration
+	timeout  time.Duration
+	retry    Retry
+
+	wakeup    chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func NewWorker(
+	log *slog.Logger,
+	buffer Buffer,
+	client test.DeviceDispatcher,
+	requests RequestSelector,
+	buffers BufferSelector,
+	devices DeviceSelector,
+	registry *Registry,
+	interval time.Duration,
+	timeout time.Duration,
+	retry Retry,
+) *Worker {
+	return &Worker{
+		log:      log.With(slog.String("component", "dispatcher.Worker")),
+		buffer:   buffer,
+		client:   client,
+		requests: requests,
+		buffers:  buffers,
+		devices:  devices,
+		registry: registry,
+		interval: interval,
+		timeout:  timeout,
+		retry:    retry,
+		wakeup:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the dispatch loop in its own goroutine. Calling it twice is a no-op.
+func (w *Worker) Start() {
+	w.startOnce.Do(func() {
+		go w.run()
+	})
+}
+
+// Stop asks the loop to exit and waits until the current tick is finished
+// or ctx is done. A worker that was never started can't be started afterwards.
+func (w *Worker) Stop(ctx context.Context) error {
+	w.startOnce.Do(func() {
+		close(w.done)
+	})
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notify wakes the loop up without waiting for the next poll. It never blocks.
+func (w *Worker) Notify() {
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Worker) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.wakeup:
+		}
+
+		w.dispatch()
+	}
+}
+
+// dispatch does one pass: it hands ready buffered tests to free devices until
+// one of the two runs out. A test leaves the buffer only after the device
+// accepted it, failed sends go back with a backoff.
+func (w *Worker) dispatch() {
+	buffered, err := w.buffer.ListTests()
+	if err != nil {
+		w.log.Error("failed to list buffered tests", slog.Any("error", err))
+		return
+	}
+
+	ready := buffered[:0]
+	for _, t := range buffered {
+		if t.Ready {
+			ready = append(ready, t)
+		}
+	}
+	if len(ready) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	devices, err := w.client.GetDeviceList(ctx)
+	cancel()
+	if err != nil {
+		w.log.Error("failed to get device list", slog.Any("error", err))
+		return
+	}
+
+	devices = w.registry.Free(devices)
+
+	w.log.Debug("getting list of free devices", slog.Any("ready", len(ready)), slog.Any("num of devices", len(devices)), slog.Any("available devices", devices))
+
+	for len(devices) > 0 {
+		next, ok := w.next(ready)
+		if !ok {
+			return
+		}
+		ready = withoutTest(ready, next.Pos)
+
+		var dev *device.DeviceResponse
+		dev, devices, ok = w.acquire(devices, next.TestRequest)
+		if !ok {
+			return
+		}
+
+		if err := w.buffer.MarkInFlight(next.Pos); err != nil {
+			// evicted or taken by someone else since ListTests
+			w.log.Debug("test is no longer dispatchable", slog.Any("pos", next.Pos), slog.Any("error", err))
+			w.registry.Abort(dev.DeviceId)
+			continue
+		}
+		devices = without(devices, dev)
+
+		w.log.Debug("try to send test", slog.Any("device", dev), slog.Any("test", next))
+		ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+		err := w.client.SendTest(ctx, dev.DeviceId, int32(next.SourceID), int32(next.TestNumber))
+		cancel()
+		if err != nil {
+			w.registry.Abort(dev.DeviceId)
+			w.fail(next, dev, err)
+			continue
+		}
+
+		if err := w.buffer.DispatchTest(next.Pos, dev.DeviceId); err != nil {
+			w.log.Error("failed to remove dispatched test", slog.Any("pos", next.Pos), slog.Any("error", err))
+		}
+	}
+}
+
+// next picks the buffer with the buffer selector and then the test of that
+// buffer with the request selector. Tests outside every buffer, left over
+// from a bigger layout, go last.
+func (w *Worker) next(ready []test.BufferedTest) (test.BufferedTest, bool) {
+	var names []string
+	for _, t := range ready {
+		if !contains(names, t.Buffer) {
+			names = append(names, t.Buffer)
+		}
+	}
+
+	name, ok := w.buffers.Next(names)
+	if !ok {
+		return w.requests.Next(ready)
+	}
+
+	var batch []test.BufferedTest
+	for _, t := range ready {
+		if t.Buffer == name {
+			batch = append(batch, t)
+		}
+	}
+	return w.requests.Next(batch)
+}
+
+// acquire picks a device for req with the device selector and reserves it in
+// the registry. Devices somebody else reserved meanwhile are dropped.
+func (w *Worker) acquire(devices []*device.DeviceResponse, req test.TestRequest) (*device.DeviceResponse, []*device.DeviceResponse, bool) {
+	for len(devices) > 0 {
+		dev, ok := w.devices.Next(devices)
+		if !ok {
+			return nil, devices, false
+		}
+		if w.registry.Acquire(dev.DeviceId, req) {
+			return dev, devices, true
+		}
+		devices = without(devices, dev)
+	}
+	return nil, devices, false
+}
+
+func (w *Worker) fail(t test.BufferedTest, dev *device.DeviceResponse, sendErr error) {
+	backoff := w.retry.delay(t.Attempts + 1)
+
+	dead, err := w.buffer.RetryTest(t.Pos, backoff, w.retry.MaxAttempts, sendErr.Error())
+	if err != nil {
+		w.log.Error("failed to return test to buffer", slog.Any("pos", t.Pos), slog.Any("error", err))
+		return
+	}
+
+	if dead {
+		w.log.Error("test moved to dead letters",
+			slog.Any("test", t.TestRequest),
+			slog.Int("attempts", t.Attempts+1),
+			slog.Any("error", sendErr),
+		)
+		return
+	}
+
+	w.log.Warn("failed to send test, will retry",
+		slog.Any("device", dev.DeviceId),
+		slog.Any("test", t.TestRequest),
+		slog.Duration("backoff", backoff),
+		slog.Any("error", sendErr),
+	)
+}
+
+func without(devices []*device.DeviceResponse, d *device.DeviceResponse) []*device.DeviceResponse {
+	rest := make([]*device.DeviceResponse, 0, len(devices))
+	for _, dev := range devices {
+		if dev != d {
+			rest = append(rest, dev)
+		}
+	}
+	return rest
+}
+
+func withoutTest(buffered []test.BufferedTest, pos int64) []test.BufferedTest {
+	rest := make([]test.BufferedTest, 0, len(buffered))
+	for _, t := range buffered {
+		if t.Pos != pos {
+			rest = append(rest, t)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,326 @@
+package dispatcher
+
+import (
+	"Dispatcher/internal/buffers"
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
+)
+
+type fakeBuffer struct {
+	mu       sync.Mutex
+	buffered []test.BufferedTest
+	dead     []test.BufferedTest
+}
+
+func newFakeBuffer(buffered ...test.BufferedTest) *fakeBuffer {
+	b := &fakeBuffer{}
+	for _, t := range buffered {
+		b.put(t)
+	}
+	return b
+}
+
+func (b *fakeBuffer) ListTests() ([]test.BufferedTest, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]test.BufferedTest(nil), b.buffered...), nil
+}
+
+func (b *fakeBuffer) DispatchTest(pos int64, _ int32) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buffered = withoutTest(b.buffered, pos)
+	return nil
+}
+
+func (b *fakeBuffer) MarkInFlight(pos int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.buffered {
+		if b.buffered[i].Pos == pos && !b.buffered[i].InFlight {
+			b.buffered[i].InFlight = true
+			b.buffered[i].Ready = false
+			return nil
+		}
+	}
+	return test.ErrTestNotFound
+}
+
+func (b *fakeBuffer) RetryTest(pos int64, backoff time.Duration, maxAttempts int, _ string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.buffered {
+		t := &b.buffered[i]
+		if t.Pos != pos || !t.InFlight {
+			continue
+		}
+		t.InFlight = false
+		t.Attempts++
+		t.Ready = backoff == 0
+		if t.Attempts >= maxAttempts {
+			b.dead = append(b.dead, *t)
+			b.buffered = append(b.buffered[:i], b.buffered[i+1:]...)
+			return true, nil
+		}
+		return false, nil
+	}
+	return false, test.ErrTestNotFound
+}
+
+func (b *fakeBuffer) put(t test.BufferedTest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t.Ready = true
+	b.buffered = append(b.buffered, t)
+}
+
+func (b *fakeBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.buffered)
+}
+
+type sent struct {
+	device, source, number int32
+}
+
+type fakeDevices struct {
+	mu      sync.Mutex
+	free    []int32
+	sent    []sent
+	sendErr error
+	calls   chan struct{}
+}
+
+func (d *fakeDevices) GetDeviceList(context.Context) ([]*device.DeviceResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return devices(d.free...), nil
+}
+
+func (d *fakeDevices) SendTest(_ context.Context, deviceId, sourceId, testNumber int32) error {
+	d.mu.Lock()
+	d.sent = append(d.sent, sent{deviceId, sourceId, testNumber})
+	err := d.sendErr
+	d.mu.Unlock()
+
+	if d.calls != nil {
+		d.calls <- struct{}{}
+	}
+	return err
+}
+
+func newTestWorker(buf Buffer, client test.DeviceDispatcher, interval time.Duration) *Worker {
+	return newLayoutWorker(buf, client, interval, &config.Config{CycleBufferConfig: config.CycleBufferConfig{MaxSize: 10}})
+}
+
+func newLayoutWorker(buf Buffer, client test.DeviceDispatcher, interval time.Duration, cfg *config.Config) *Worker {
+	layout, err := buffers.New(cfg, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return NewWorker(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		buf,
+		client,
+		priority{},
+		strictPriority{layout},
+		firstFree{},
+		NewRegistry(0),
+		interval,
+		time.Second,
+		Retry{MaxAttempts: 2, Backoff: time.Hour, MaxBackoff: time.Hour},
+	)
+}
+
+func TestWorkerDispatchesToEveryFreeDevice(t *testing.T) {
+	buf := newFakeBuffer(
+		test.BufferedTest{Pos: 0, TestRequest: test.TestRequest{SourceID: 2, TestNumber: 1}},
+		test.BufferedTest{Pos: 1, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}},
+		test.BufferedTest{Pos: 2, TestRequest: test.TestRequest{SourceID: 3, TestNumber: 1}},
+	)
+	client := &fakeDevices{free: []int32{7, 8}}
+
+	newTestWorker(buf, client, time.Hour).dispatch()
+
+	want := []sent{{7, 1, 1}, {8, 2, 1}}
+	if len(client.sent) != len(want) {
+		t.Fatalf("sent = %v, want %v", client.sent, want)
+	}
+	for i := range want {
+		if client.sent[i] != want[i] {
+			t.Fatalf("sent = %v, want %v", client.sent, want)
+		}
+	}
+
+	if buf.len() != 1 {
+		t.Errorf("buffer holds %d tests, want 1", buf.len())
+	}
+}
+
+func TestWorkerDrainsBuffersByPriority(t *testing.T) {
+	cfg := &config.Config{Buffers: []config.Buffer{
+		{Name: "bulk", MaxSize: 2, Priority: 1},
+		{Name: "urgent", MaxSize: 2},
+	}}
+	buf := newFakeBuffer(
+		test.BufferedTest{Buffer: "bulk", Pos: 0, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}},
+		test.BufferedTest{Buffer: "urgent", Pos: 2, TestRequest: test.TestRequest{SourceID: 5, TestNumber: 1}},
+		test.BufferedTest{Buffer: "urgent", Pos: 3, TestRequest: test.TestRequest{SourceID: 6, TestNumber: 1}},
+		test.BufferedTest{Pos: 9, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 2}},
+	)
+	client := &fakeDevices{free: []int32{1, 2, 3, 4}}
+
+	newLayoutWorker(buf, client, time.Hour, cfg).dispatch()
+
+	// the test outside every buffer comes last
+	want := []sent{{1, 5, 1}, {2, 6, 1}, {3, 1, 1}, {4, 1, 2}}
+	if len(client.sent) != len(want) {
+		t.Fatalf("sent = %v, want %v", client.sent, want)
+	}
+	for i := range want {
+		if client.sent[i] != want[i] {
+			t.Fatalf("sent = %v, want %v", client.sent, want)
+		}
+	}
+}
+
+func TestWorkerSkipsDeviceListOnEmptyBuffer(t *testing.T) {
+	client := &fakeDevices{free: []int32{1}}
+
+	newTestWorker(&fakeBuffer{}, client, time.Hour).dispatch()
+
+	if len(client.sent) != 0 {
+		t.Errorf("sent %v from an empty buffer", client.sent)
+	}
+}
+
+func TestWorkerNotifyWakesUpImmediately(t *testing.T) {
+	buf := &fakeBuffer{}
+	client := &fakeDevices{free: []int32{1}, calls: make(chan struct{}, 1)}
+
+	w := newTestWorker(buf, client, time.Hour)
+	w.Start()
+	defer w.Stop(context.Background())
+
+	buf.put(test.BufferedTest{Pos: 0, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}})
+	w.Notify()
+
+	select {
+	case <-client.calls:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("test was not dispatched after Notify")
+	}
+}
+
+func TestWorkerPollsOnInterval(t *testing.T) {
+	buf := newFakeBuffer(test.BufferedTest{Pos: 0, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}})
+	client := &fakeDevices{free: []int32{1}, calls: make(chan struct{}, 1)}
+
+	w := newTestWorker(buf, client, 10*time.Millisecond)
+	w.Start()
+	defer w.Stop(context.Background())
+
+	select {
+	case <-client.calls:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("test was not dispatched by polling")
+	}
+}
+
+func TestWorkerStop(t *testing.T) {
+	w := newTestWorker(&fakeBuffer{}, &fakeDevices{}, time.Hour)
+	w.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := w.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if err := w.Stop(ctx); err != nil {
+		t.Fatalf("second Stop: %v", err)
+	}
+}
+
+func TestWorkerStopWithoutStart(t *testing.T) {
+	w := newTestWorker(&fakeBuffer{}, &fakeDevices{}, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := w.Stop(ctx); err != nil {
+		t.Fatalf("Stop on a never started worker: %v", err)
+	}
+}
+
+func TestWorkerRetriesFailedSend(t *testing.T) {
+	buf := newFakeBuffer(test.BufferedTest{Pos: 0, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}})
+	client := &fakeDevices{free: []int32{1}, sendErr: errors.New("device is gone")}
+	w := newTestWorker(buf, client, time.Hour)
+
+	w.dispatch()
+
+	left, _ := buf.ListTests()
+	if len(left) != 1 {
+		t.Fatalf("failed test left the buffer")
+	}
+	if left[0].Attempts != 1 || left[0].InFlight || left[0].Ready {
+		t.Fatalf("after a failed send test = %+v, want one attempt, waiting for backoff", left[0])
+	}
+
+	// still in backoff, nothing is sent
+	w.dispatch()
+	if len(client.sent) != 1 {
+		t.Fatalf("sent %d times during backoff, want 1", len(client.sent))
+	}
+
+	buf.buffered[0].Ready = true
+	w.dispatch()
+
+	if len(buf.buffered) != 0 || len(buf.dead) != 1 {
+		t.Fatalf("after %d failed sends buffered = %v, dead = %v", len(client.sent), buf.buffered, buf.dead)
+	}
+}
+
+func TestWorkerSkipsInFlightTests(t *testing.T) {
+	buf := newFakeBuffer(test.BufferedTest{Pos: 0, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}})
+	if err := buf.MarkInFlight(0); err != nil {
+		t.Fatalf("MarkInFlight: %v", err)
+	}
+	client := &fakeDevices{free: []int32{1}}
+
+	newTestWorker(buf, client, time.Hour).dispatch()
+
+	if len(client.sent) != 0 {
+		t.Errorf("in-flight test was sent again: %v", client.sent)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := Retry{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := r.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
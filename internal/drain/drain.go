@@ -0,0 +1,95 @@
+// Package drain works off persistent queues: every interval, and once more
+// when stopped, batches are taken from the queue until it is empty or a batch
+// fails. The outbox relay and the refusal notifier are built on it.
+package drain
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Batch handles up to size pending rows, oldest first, and returns how many
+// of them are done. An error ends the round, the rest is retried next tick.
+type Batch func(size int) (int, error)
+
+// Loop calls its batch until the queue is drained.
+type Loop struct {
+	log      *slog.Logger
+	batch    Batch
+	size     int
+	interval time.Duration
+
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func New(log *slog.Logger, batch Batch, size int, interval time.Duration) *Loop {
+	return &Loop{
+		log:      log,
+		batch:    batch,
+		size:     size,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the loop in its own goroutine. Calling it twice is a no-op.
+func (l *Loop) Start() {
+	l.startOnce.Do(func() {
+		go l.run()
+	})
+}
+
+// Stop drains what is left and waits for the loop to exit or ctx to be done.
+// A loop that was never started can't be started afterwards.
+func (l *Loop) Stop(ctx context.Context) error {
+	l.startOnce.Do(func() {
+		close(l.done)
+	})
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Loop) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			l.Drain()
+			return
+		case <-ticker.C:
+			l.Drain()
+		}
+	}
+}
+
+// Drain runs batches until one comes back short or fails.
+func (l *Loop) Drain() {
+	for {
+		n, err := l.batch(l.size)
+		if err != nil {
+			l.log.Error("failed to drain queue, will retry", slog.Any("error", err))
+			return
+		}
+		if n < l.size {
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queue is a queue of n rows that fails a batch once budget rows are done.
+type queue struct {
+	mu      sync.Mutex
+	pending int
+	budget  int
+	batches []int
+}
+
+func (q *queue) batch(size int) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := min(size, q.pending)
+	q.batches = append(q.batches, n)
+	if n > q.budget {
+		q.pending -= q.budget
+		q.budget = 0
+		return 0, errors.New("downstream is down")
+	}
+	q.pending -= n
+	q.budget -= n
+	return n, nil
+}
+
+func newTestLoop(q *queue) *Loop {
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), q.batch, 2, time.Hour)
+}
+
+func TestDrainInBatches(t *testing.T) {
+	q := &queue{pending: 5, budget: 10}
+	newTestLoop(q).Drain()
+
+	if q.pending != 0 {
+		t.Errorf("%d rows left, want none", q.pending)
+	}
+	if want := []int{2, 2, 1}; !equal(q.batches, want) {
+		t.Errorf("batches = %v, want %v", q.batches, want)
+	}
+}
+
+func TestDrainStopsOnError(t *testing.T) {
+	q := &queue{pending: 5, budget: 3}
+	l := newTestLoop(q)
+
+	l.Drain()
+	if q.pending != 2 {
+		t.Fatalf("%d rows left after failure, want 2", q.pending)
+	}
+	if want := []int{2, 2}; !equal(q.batches, want) {
+		t.Errorf("batches = %v, want %v", q.batches, want)
+	}
+
+	q.budget = 10
+	l.Drain()
+	if q.pending != 0 {
+		t.Errorf("%d rows left after retry, want none", q.pending)
+	}
+}
+
+func TestStopDrains(t *testing.T) {
+	q := &queue{pending: 3, budget: 10}
+	l := newTestLoop(q)
+	l.Start()
+
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if q.pending != 0 {
+		t.Errorf("%d rows left on stop, want none", q.pending)
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	q := &queue{pending: 3, budget: 10}
+	l := newTestLoop(q)
+
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	l.Start()
+	if len(q.batches) != 0 {
+		t.Errorf("a stopped loop ran batches %v", q.batches)
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,62 +1,132 @@
 package entrypoint
 
 import (
+	"Dispatcher/internal/admission"
+	"Dispatcher/internal/buffers"
 	grpcDevice "Dispatcher/internal/client/DeviceService/grpc"
+	"Dispatcher/internal/client/Kafka/consumer"
+	"Dispatcher/internal/client/Kafka/producer"
+	httpclient "Dispatcher/internal/client/UserService/http"
 	"Dispatcher/internal/config"
+	"Dispatcher/internal/dispatcher"
+	"Dispatcher/internal/events"
+	grpcserver "Dispatcher/internal/grpc-server"
+	"Dispatcher/internal/http-server/handlers/batch"
+	"Dispatcher/internal/http-server/handlers/health"
+	"Dispatcher/internal/http-server/handlers/lifecycle"
+	"Dispatcher/internal/http-server/handlers/result"
+	statsHandler "Dispatcher/internal/http-server/handlers/stats"
 	"Dispatcher/internal/http-server/handlers/test"
 	"Dispatcher/internal/logger"
+	"Dispatcher/internal/outbox"
+	"Dispatcher/internal/refusal"
+	"Dispatcher/internal/results"
+	"Dispatcher/internal/sources"
+	"Dispatcher/internal/stats"
+	"Dispatcher/internal/storage/memory"
 	storage "Dispatcher/internal/storage/postgres"
 	"context"
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
-	"log"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 )
 
 type (
 	// Entrypoint entrypoint methods.
 	Entrypoint interface {
+		// Run serves until SIGINT/SIGTERM or a server failure and then shuts down.
 		Run() error
+		// Shutdown stops accepting requests, lets the dispatch loop finish its
+		// tick, flushes Kafka and closes the gRPC and DB connections.
+		Shutdown(ctx context.Context) error
+	}
+
+	buffer interface {
+		test.TestCycleBuffer
+		io.Closer
 	}
 
 	entrypoint struct {
 		cfg           *config.Config
 		logger        *slog.Logger
-		kafkaProducer *kafka.Producer
-		st            *storage.Storage
+		kafkaProducer *producer.Producer
+		st            buffer
 		router        *chi.Mux
+		srv           *http.Server
 		grpcClient    *grpcDevice.Client
+		worker        *dispatcher.Worker
+		registry      *dispatcher.Registry
+		stats         *stats.Collector
+		statsPub      *stats.Publisher
+		relay         *outbox.Relay
+		refusals      *refusal.Notifier
+		results       *results.Forwarder
+		consumer      *consumer.Consumer
+		hub           *events.Hub
+		grpcServer    *grpcserver.Server
 		mu            sync.Mutex
 		startTime     time.Time
 		eventCount    int
+
+		shutdownOnce sync.Once
+		shutdownErr  error
 	}
 )
 
-func New(cfg *config.Config) (Entrypoint, error) {
+func New(cfg *config.Config) (_ Entrypoint, err error) {
 	ep := &entrypoint{cfg: cfg}
 
-	var err error
+	// what was opened so far is closed again, newest first, when a later
+	// step fails
+	var cleanups []func()
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}()
 
 	ep.logger = logger.SetupLogger(ep.cfg.Env)
 	ep.logger.Info("UserService starts", slog.String("env", cfg.Env))
 
+	sourceTable, err := sources.New(cfg.Sources, cfg.UnknownSources)
+	if err != nil {
+		ep.logger.Error("Ошибка конфигурации источников", "error", err)
+		return nil, err
+	}
+
+	layout, err := buffers.New(cfg, sourceTable)
+	if err != nil {
+		ep.logger.Error("Ошибка конфигурации буферов", "error", err)
+		return nil, err
+	}
+
 	// init db
-	ep.st, err = storage.New(cfg, ep.logger)
+	ep.st, err = newStorage(cfg, ep.logger, sourceTable, layout)
 	if err != nil {
 		ep.logger.Error("Ошибка создания хранилища", "error", err)
 		return nil, err
 	}
+	cleanups = append(cleanups, func() { ep.st.Close() })
 
 	ep.logger.Info("Connecting to kafka")
-	ep.kafkaProducer, err = kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": cfg.KafkaProducer.Broker})
+	ep.kafkaProducer, err = producer.New(ep.logger, cfg.KafkaProducer)
 	if err != nil {
 		ep.logger.Error("Ошибка создания Kafka producer", "error", err)
 		return nil, err
 	}
+	cleanups = append(cleanups, ep.kafkaProducer.Close)
 	ep.logger.Info("Connected to kafka")
 
 	// init gRPC client
@@ -64,6 +134,92 @@ func New(cfg *config.Config) (Entrypoint, error) {
 		ep.logger,
 		ep.cfg.GRPCClient.Address,
 	)
+	if err != nil {
+		ep.logger.Error("Ошибка создания gRPC клиента", "error", err)
+		return nil, err
+	}
+	ep.grpcClient = grpcClient
+	cleanups = append(cleanups, func() { ep.grpcClient.Close() })
+
+	requestSelector, err := dispatcher.NewRequestSelector(cfg.DispatchConfig.RequestPolicy, sourceTable)
+	if err != nil {
+		ep.logger.Error("Ошибка выбора политики выборки заявок", "error", err)
+		return nil, err
+	}
+
+	bufferSelector, err := dispatcher.NewBufferSelector(cfg.DispatchConfig.BufferPolicy, layout)
+	if err != nil {
+		ep.logger.Error("Ошибка выбора политики выбора буферов", "error", err)
+		return nil, err
+	}
+
+	deviceSelector, err := dispatcher.NewDeviceSelector(cfg.DispatchConfig.DevicePolicy)
+	if err != nil {
+		ep.logger.Error("Ошибка выбора политики выбора приборов", "error", err)
+		return nil, err
+	}
+
+	ep.registry = dispatcher.NewRegistry(cfg.DispatchConfig.BusyGrace)
+
+	ep.stats = stats.NewCollector(ep.registry)
+	ep.st.Subscribe(ep.stats)
+
+	ep.hub = events.NewHub(ep.logger)
+	ep.st.Subscribe(ep.hub)
+	ep.registry.Subscribe(ep.hub)
+
+	// request events go through the outbox when the storage has one, device
+	// events are not tied to a buffer change and are always sent directly
+	eventPublisher := events.NewPublisher(ep.logger, ep.kafkaProducer, cfg.KafkaProducer.EventsTopic)
+	ep.registry.Subscribe(eventPublisher)
+	if store, ok := ep.st.(outbox.Store); ok && !cfg.OutboxConfig.Disabled {
+		ep.relay = outbox.NewRelay(
+			ep.logger,
+			store,
+			ep.kafkaProducer,
+			cfg.KafkaProducer.EventsTopic,
+			cfg.OutboxConfig.BatchSize,
+			cfg.OutboxConfig.Interval,
+			cfg.KafkaProducer.FlushTimeout,
+		)
+	} else {
+		ep.st.Subscribe(eventPublisher)
+	}
+	userService := httpclient.New(cfg.UserService)
+	ep.refusals = refusal.NewNotifier(
+		ep.logger,
+		ep.st,
+		userService,
+		cfg.RefusalConfig.BatchSize,
+		cfg.RefusalConfig.Interval,
+		cfg.RefusalConfig.Timeout,
+	)
+	ep.results = results.NewForwarder(
+		ep.logger,
+		ep.st,
+		userService,
+		cfg.ResultConfig.BatchSize,
+		cfg.ResultConfig.Interval,
+		cfg.ResultConfig.Timeout,
+	)
+	ep.statsPub = stats.NewPublisher(ep.logger, ep.stats, ep.kafkaProducer, cfg.StatsConfig.Topic, cfg.StatsConfig.Interval)
+
+	ep.worker = dispatcher.NewWorker(
+		ep.logger,
+		ep.st,
+		grpcClient,
+		requestSelector,
+		bufferSelector,
+		deviceSelector,
+		ep.registry,
+		cfg.DispatchConfig.PollInterval,
+		cfg.GRPCClient.Timeout,
+		dispatcher.Retry{
+			MaxAttempts: cfg.DispatchConfig.MaxAttempts,
+			Backoff:     cfg.DispatchConfig.RetryBackoff,
+			MaxBackoff:  cfg.DispatchConfig.MaxBackoff,
+		},
+	)
 
 	router := chi.NewRouter()
 
@@ -71,59 +227,51 @@ func New(cfg *config.Config) (Entrypoint, error) {
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.URLFormat)
 
-	http_handler := test.NewHandler(ep.st, grpcClient, ep.kafkaProducer, ep.cfg)
+	http_handler := test.NewHandler(ep.st, grpcClient, deviceSelector, ep.registry, ep.worker, ep.kafkaProducer, sourceTable, admission.New(cfg.Admission, sourceTable), ep.cfg)
 	router.Post("/test", test.New(
 		ep.logger,
 		http_handler,
 	))
+	router.Post("/tests/batch", batch.New(ep.logger, http_handler))
+	if cfg.KafkaConsumer.Enabled {
+		ep.consumer, err = consumer.New(ep.logger, cfg.KafkaConsumer, http_handler)
+		if err != nil {
+			ep.logger.Error("Ошибка создания Kafka consumer", "error", err)
+			return nil, err
+		}
+	}
+
+	if cfg.GRPCServer.Address != "" {
+		ep.grpcServer = grpcserver.NewServer(grpcserver.NewService(ep.logger, http_handler, ep.st, ep.hub))
+	}
+
+	router.Get("/tests/{source}/{number}", lifecycle.New(ep.logger, ep.st))
+	router.Post("/results", result.New(ep.logger, ep.st, ep.registry, ep.worker))
+	router.Get("/stats", statsHandler.New(ep.stats))
+	router.Get("/ready", health.New(map[string]health.Checker{
+		"kafka": ep.kafkaProducer,
+	}))
 
 	ep.router = router
 
-	go func(client *grpcDevice.Client) {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-
-				devices, err := client.GetDeviceList(ctx)
-				if err != nil {
-					continue
-				}
-				availableSpace, err := ep.st.CheckAvailableSpace()
-				if err != nil {
-					ep.logger.Error(err.Error())
-					continue
-				}
-				if availableSpace == cfg.MaxSize {
-					continue
-				}
-				ep.logger.Debug("getting list of free devices", slog.Any("available space", availableSpace), slog.Any("num of devices", len(devices)), slog.Any("available devices", devices))
-				if len(devices) > 0 {
-					for _, device := range devices {
-						pos, sourceNum, requestNum, err := ep.st.GetTest()
-						if err != nil {
-							ep.logger.Error(err.Error())
-							break
-						}
-						if sourceNum == -1 && requestNum == -1 {
-							break
-						}
-						ep.logger.Debug("try to send test", slog.Any("device", device))
-						client.SendTest(ctx, device.DeviceId, int32(sourceNum), int32(requestNum))
-						err = ep.st.DeleteTest(pos)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-
-				cancel()
-			}
-		}
-	}(grpcClient)
+	ep.srv = &http.Server{
+		Addr:         ep.cfg.HTTPServer.Address,
+		Handler:      ep.router,
+		ReadTimeout:  ep.cfg.HTTPServer.Timeout,
+		WriteTimeout: ep.cfg.HTTPServer.Timeout,
+		IdleTimeout:  ep.cfg.HTTPServer.IdleTimeout,
+	}
+
+	ep.worker.Start()
+	ep.refusals.Start()
+	ep.results.Start()
+	ep.statsPub.Start()
+	if ep.relay != nil {
+		ep.relay.Start()
+	}
+	if ep.consumer != nil {
+		ep.consumer.Start()
+	}
 
 	ep.logger.Info("Creating was finished")
 
@@ -131,18 +279,132 @@ func New(cfg *config.Config) (Entrypoint, error) {
 }
 
 func (ep *entrypoint) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	ep.logger.Info("Starting server")
-	srv := &http.Server{
-		Addr:         ep.cfg.HTTPServer.Address,
-		Handler:      ep.router,
-		ReadTimeout:  ep.cfg.HTTPServer.Timeout,
-		WriteTimeout: ep.cfg.HTTPServer.Timeout,
-		IdleTimeout:  ep.cfg.HTTPServer.IdleTimeout,
+	serveErr := make(chan error, 2)
+	go func() {
+		err := ep.srv.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		serveErr <- err
+	}()
+
+	if ep.grpcServer != nil {
+		ep.logger.Info("Starting gRPC server", slog.String("address", ep.cfg.GRPCServer.Address))
+		go func() {
+			lis, err := net.Listen("tcp", ep.cfg.GRPCServer.Address)
+			if err == nil {
+				err = ep.grpcServer.Serve(lis)
+			}
+			serveErr <- err
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		ep.logger.Info("Shutdown signal received")
+	case err = <-serveErr:
+		if err != nil {
+			ep.logger.Error("failed to start server", slog.Any("error", err))
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ep.cfg.HTTPServer.ShutdownTimeout)
+	defer cancel()
+
+	return errors.Join(err, ep.Shutdown(shutdownCtx))
+}
+
+func (ep *entrypoint) Shutdown(ctx context.Context) error {
+	ep.shutdownOnce.Do(func() {
+		ep.shutdownErr = ep.shutdown(ctx)
+	})
+	return ep.shutdownErr
+}
+
+func (ep *entrypoint) shutdown(ctx context.Context) error {
+	var errs []error
+
+	ep.logger.Info("Stopping http server")
+	if err := ep.srv.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http server shutdown: %w", err))
+	}
+
+	if ep.grpcServer != nil {
+		ep.logger.Info("Stopping gRPC server")
+		if err := ep.grpcServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("grpc server shutdown: %w", err))
+		}
+	}
+
+	if ep.consumer != nil {
+		ep.logger.Info("Stopping kafka consumer")
+		if err := ep.consumer.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("kafka consumer: %w", err))
+		}
+	}
+
+	ep.logger.Info("Waiting for dispatch worker")
+	if err := ep.worker.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("dispatch worker: %w", err))
+	}
+
+	ep.logger.Info("Notifying sources about the rest of refused tests")
+	if err := ep.refusals.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("refusal notifier: %w", err))
+	}
+
+	ep.logger.Info("Forwarding the rest of test results")
+	if err := ep.results.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("result forwarder: %w", err))
+	}
+
+	ep.logger.Info("Publishing final stats")
+	if err := ep.statsPub.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("stats publisher: %w", err))
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		ep.logger.Error("failed to start server", slog.Any("error", err))
+	if ep.relay != nil {
+		ep.logger.Info("Relaying the rest of the outbox")
+		if err := ep.relay.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("outbox relay: %w", err))
+		}
+	}
+
+	ep.logger.Info("Flushing kafka producer")
+	if left := ep.kafkaProducer.Flush(int(ep.cfg.KafkaProducer.FlushTimeout.Milliseconds())); left > 0 {
+		errs = append(errs, fmt.Errorf("kafka flush: %d messages were not delivered", left))
+	}
+	ep.kafkaProducer.Close()
+
+	if err := ep.grpcClient.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("grpc client close: %w", err))
+	}
+
+	if err := ep.st.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("storage close: %w", err))
 	}
 
+	if len(errs) > 0 {
+		ep.logger.Error("Shutdown finished with errors", slog.Any("error", errors.Join(errs...)))
+		return errors.Join(errs...)
+	}
+
+	ep.logger.Info("Shutdown finished")
 	return nil
 }
+
+func newStorage(cfg *config.Config, log *slog.Logger, table *sources.Table, layout *buffers.Layout) (buffer, error) {
+	switch cfg.StorageConfig.Driver {
+	case "memory":
+		return memory.New(log, table, layout)
+	case "", "postgres":
+		return storage.New(cfg, log, table, layout)
+	}
+
+	return nil, fmt.Errorf("unknown storage driver: %q", cfg.StorageConfig.Driver)
+}
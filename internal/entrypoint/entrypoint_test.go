@@ -0,0 +1,576 @@
+package entrypoint
+
+import (
+	fakeDevice "Dispatcher/internal/client/DeviceService/fake"
+	httpclient "Dispatcher/internal/client/UserService/http"
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/batch"
+	"Dispatcher/internal/http-server/handlers/lifecycle"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"Dispatcher/internal/stats"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testConfig(deviceAddr string) *config.Config {
+	return &config.Config{
+		Env:           "local",
+		StorageConfig: config.StorageConfig{Driver: "memory"},
+		HTTPServer: config.HTTPServer{
+			Address:         "127.0.0.1:0",
+			Timeout:         time.Second,
+			IdleTimeout:     time.Second,
+			ShutdownTimeout: time.Second,
+		},
+		GRPCClient: config.GRPCClient{
+			Address: deviceAddr,
+			Timeout: time.Second,
+		},
+		KafkaProducer: config.KafkaProducer{
+			// nothing listens there, messages just stay in the producer queue
+			Broker:        "127.0.0.1:1",
+			Topic:         "analytics",
+			FlushTimeout:  100 * time.Millisecond,
+			SpoolSize:     100,
+			MaxRetries:    1,
+			RetryInterval: 50 * time.Millisecond,
+		},
+		CycleBufferConfig: config.CycleBufferConfig{MaxSize: 20},
+		UserService:       config.UserService{BaseURL: "http://127.0.0.1:1"},
+		RefusalConfig: config.RefusalConfig{
+			BatchSize: 10,
+			Interval:  20 * time.Millisecond,
+			Timeout:   time.Second,
+		},
+		ResultConfig: config.ResultConfig{
+			BatchSize: 10,
+			Interval:  20 * time.Millisecond,
+			Timeout:   time.Second,
+		},
+		DispatchConfig: config.DispatchConfig{
+			PollInterval: 20 * time.Millisecond,
+			MaxAttempts:  3,
+			RetryBackoff: 10 * time.Millisecond,
+			MaxBackoff:   100 * time.Millisecond,
+			BusyGrace:    50 * time.Millisecond,
+		},
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	const tests = 10
+
+	devices := fakeDevice.New(2, fakeDevice.Constant(30*time.Millisecond))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	ep, err := New(testConfig(addr))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	for i := 1; i <= tests; i++ {
+		body := fmt.Sprintf(`{"source_id": 1, "test_number": %d}`, i)
+		resp, err := http.Post(srv.URL+"/test", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("POST /test: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(devices.Served()) < tests && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	served := devices.Served()
+	if len(served) != tests {
+		t.Fatalf("devices served %d tests, want %d", len(served), tests)
+	}
+
+	seen := make(map[uint32]bool)
+	for _, s := range served {
+		if seen[s.TestNumber] {
+			t.Errorf("test %d was served twice", s.TestNumber)
+		}
+		seen[s.TestNumber] = true
+	}
+
+	for i := 1; i <= tests; i++ {
+		resp, err := http.Get(fmt.Sprintf("%s/tests/1/%d", srv.URL, i))
+		if err != nil {
+			t.Fatalf("GET lifecycle: %v", err)
+		}
+
+		var got lifecycle.Response
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode lifecycle: %v", err)
+		}
+
+		n := len(got.Transitions)
+		if n < 2 || got.Transitions[0].State != test.StateReceived || got.Transitions[n-1].State != test.StateDispatched {
+			t.Errorf("lifecycle of 1/%d = %+v, want received ... dispatched", i, got.Transitions)
+		}
+	}
+
+	// the fake devices never report and their tests expire after the busy
+	// grace, so a device DeviceService does not list reports instead
+	ep.(*entrypoint).registry.Acquire(9, test.TestRequest{SourceID: 1, TestNumber: 1})
+
+	postResult := func(body string, want int) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/results", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("POST /results: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("POST /results %s status = %d, want %d", body, resp.StatusCode, want)
+		}
+	}
+	postResult(`{"source_id": 1, "test_number": 1, "result": true}`, http.StatusBadRequest)
+	postResult(`{"device_id": 9, "source_id": 1, "test_number": 2, "result": true}`, http.StatusConflict)
+	body := `{"device_id": 9, "source_id": 1, "test_number": 1, "result": true, "duration_ms": 30, "msg": "ok"}`
+	postResult(body, http.StatusOK)
+	postResult(body, http.StatusConflict)
+
+	history, _ := ep.(*entrypoint).st.GetLifecycle(1, 1)
+	if last := history[len(history)-1]; last.State != test.StateCompleted || last.DeviceID == nil || *last.DeviceID != 9 {
+		t.Errorf("last transition after result = %+v, want completed on device 9", last)
+	}
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatalf("GET /stats: %v", err)
+	}
+	var report stats.Report
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if len(report.Sources) != 1 || report.Sources[0].Generated != tests || report.Sources[0].Completed != 1 {
+		t.Errorf("stats sources = %+v, want %d generated and 1 completed", report.Sources, tests)
+	}
+	if len(report.Devices) != 3 {
+		t.Errorf("stats devices = %+v, want the two fake devices and device 9", report.Devices)
+	}
+
+	resp, err = http.Get(srv.URL + "/tests/1/999")
+	if err != nil {
+		t.Fatalf("GET lifecycle: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown test lifecycle status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	devices := fakeDevice.New(2, fakeDevice.Constant(time.Second))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	cfg := testConfig(addr)
+	cfg.CycleBufferConfig.MaxSize = 2
+	ep, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	body := `[{"source_id": 1, "test_number": 1}, {"source_id": 1, "test_number": 2},
+		{"source_id": 1, "test_number": 3}, {"source_id": 1, "test_number": 4},
+		{"source_id": 1, "test_number": 5}]`
+	resp, err := http.Post(srv.URL+"/tests/batch", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("POST /tests/batch: %v", err)
+	}
+	var got batch.Response
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode batch response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(got.Results) != 5 {
+		t.Fatalf("POST /tests/batch = %d %+v, want 200 with 5 results", resp.StatusCode, got)
+	}
+
+	outcomes := []string{test.OutcomeDispatched, test.OutcomeDispatched, test.OutcomeBuffered, test.OutcomeBuffered, test.OutcomeBuffered}
+	for i, res := range got.Results {
+		if res.TestNumber != uint(i+1) || res.Outcome != outcomes[i] {
+			t.Errorf("result %d = %+v, want 1/%d %s", i, res, i+1, outcomes[i])
+		}
+	}
+	if got.Results[0].DeviceID == nil || got.Results[1].DeviceID == nil {
+		t.Errorf("dispatched results have no device: %+v", got.Results[:2])
+	}
+	if ev := got.Results[4].Evicted; ev == nil || ev.TestNumber != 3 {
+		t.Errorf("last result evicted %v, want 1/3", ev)
+	}
+	if p := got.Results[4].Pos; p == nil || got.Results[2].Pos == nil || *p != *got.Results[2].Pos {
+		t.Errorf("last result took position %v, want the one of 1/3", p)
+	}
+
+	resp, err = http.Post(srv.URL+"/tests/batch", "application/json", bytes.NewBufferString(`[]`))
+	if err != nil {
+		t.Fatalf("POST /tests/batch: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty batch status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPostTestResponses(t *testing.T) {
+	devices := fakeDevice.New(1, fakeDevice.Constant(time.Second))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	refused := make(chan httpclient.Refusal, 10)
+	userService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got httpclient.Refusal
+		if err := json.NewDecoder(r.Body).Decode(&got); err == nil && r.URL.Path == "/test" {
+			refused <- got
+		}
+	}))
+	defer userService.Close()
+
+	cfg := testConfig(addr)
+	cfg.CycleBufferConfig.MaxSize = 1
+	cfg.UserService.BaseURL = userService.URL
+	ep, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	post := func(body string) (int, test.AdmitResponse) {
+		t.Helper()
+
+		resp, err := http.Post(srv.URL+"/test", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("POST /test: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var got test.AdmitResponse
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp.StatusCode, got
+	}
+
+	code, got := post(`{"source_id": 1, "test_number": 1}`)
+	if code != http.StatusOK || got.Result.Outcome != test.OutcomeDispatched || got.Result.DeviceID == nil {
+		t.Errorf("first test = %d %+v, want dispatched", code, got)
+	}
+
+	code, got = post(`{"source_id": 1, "test_number": 2}`)
+	if code != http.StatusOK || got.Result.Outcome != test.OutcomeBuffered || got.Result.Pos == nil || got.Result.Evicted != nil {
+		t.Errorf("second test = %d %+v, want buffered", code, got)
+	}
+
+	code, got = post(`{"source_id": 2, "test_number": 1}`)
+	if code != http.StatusOK || got.Result.Evicted == nil || *got.Result.Evicted != (test.TestRequest{SourceID: 1, TestNumber: 2}) {
+		t.Errorf("third test = %d %+v, want buffered evicting 1/2", code, got)
+	}
+	if want := fmt.Sprintf("buffered at position %d, evicted request 1/2", *got.Result.Pos); got.Message != want {
+		t.Errorf("third test message = %q, want %q", got.Message, want)
+	}
+
+	select {
+	case got := <-refused:
+		if got.TestReq != (test.TestRequest{SourceID: 1, TestNumber: 2}) || got.Status || got.Reason != test.StateEvicted || got.RemovalTime.IsZero() {
+			t.Errorf("UserService got refusal %+v, want evicted 1/2", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("UserService was not told about the evicted test")
+	}
+
+	for _, body := range []string{``, `not json`, `{"source_id": 1}`, `{"source_id": 1, "test_number": 4294967295}`} {
+		if code, got := post(body); code != http.StatusBadRequest || got.Status != "error" {
+			t.Errorf("POST /test %q = %d %+v, want 400", body, code, got)
+		}
+	}
+}
+
+func TestUnknownSources(t *testing.T) {
+	devices := fakeDevice.New(1, fakeDevice.Constant(time.Second))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	cfg := testConfig(addr)
+	cfg.Sources = []config.Source{{ID: 1}}
+	cfg.UnknownSources.Policy = sources.UnknownReject
+	ep, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	for _, c := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/test", `{"source_id": 2, "test_number": 1}`, http.StatusForbidden},
+		{"/tests/batch", `[{"source_id": 1, "test_number": 1}, {"source_id": 2, "test_number": 1}]`, http.StatusForbidden},
+		{"/test", `{"source_id": 1, "test_number": 2}`, http.StatusOK},
+	} {
+		resp, err := http.Post(srv.URL+c.path, "application/json", bytes.NewBufferString(c.body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", c.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("POST %s %s = %d, want %d", c.path, c.body, resp.StatusCode, c.want)
+		}
+	}
+
+	// the refused batch left nothing behind, 1/2 was dispatched
+	if space, err := ep.(*entrypoint).st.CheckAvailableSpace(); err != nil || space != cfg.CycleBufferConfig.MaxSize {
+		t.Errorf("available space = %d, %v, want an empty buffer", space, err)
+	}
+}
+
+func TestAdmissionControl(t *testing.T) {
+	devices := fakeDevice.New(1, fakeDevice.Constant(time.Second))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	cfg := testConfig(addr)
+	cfg.Sources = []config.Source{
+		{ID: 1, Rate: 0.001},
+		{ID: 2, MaxBuffered: 1},
+	}
+	cfg.Admission.RetryAfter = 2 * time.Second
+	ep, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	post := func(path, body string) *http.Response {
+		t.Helper()
+
+		resp, err := http.Post(srv.URL+path, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		return resp
+	}
+
+	resp := post("/test", `{"source_id": 1, "test_number": 1}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first test of source 1 = %d, want 200", resp.StatusCode)
+	}
+	resp = post("/test", `{"source_id": 1, "test_number": 2}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1000" {
+		t.Errorf("second test of source 1 = %d, Retry-After %q, want 429 after 1000s", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	resp = post("/tests/batch", `[{"source_id": 2, "test_number": 1}, {"source_id": 2, "test_number": 2}, {"source_id": 2, "test_number": 3}]`)
+	var got batch.Response
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode batch response: %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("batch of source 2 = %d, Retry-After %q, want 429 after 2s", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if len(got.Results) != 1 || got.Results[0].TestNumber != 1 {
+		t.Errorf("batch results = %+v, want 2/1 admitted", got.Results)
+	}
+
+	refused := map[uint]int64{1: 1, 2: 2}
+	for _, s := range ep.(*entrypoint).stats.Report().Sources {
+		if s.Refused != refused[s.SourceID] {
+			t.Errorf("source %d refused %d tests, want %d", s.SourceID, s.Refused, refused[s.SourceID])
+		}
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	devices := fakeDevice.New(1, fakeDevice.Constant(time.Second))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	cfg := testConfig(addr)
+	cfg.Admission.MaxInFlight = 2
+	ep, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	// the first test runs on the only device, the second waits in the buffer
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		resp, err := http.Post(srv.URL+"/test", "application/json", bytes.NewBufferString(fmt.Sprintf(`{"source_id": 1, "test_number": %d}`, i+1)))
+		if err != nil {
+			t.Fatalf("POST /test: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("test %d = %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestConcurrentAdmission(t *testing.T) {
+	const posts = 20
+
+	// without devices every admitted test stays in the buffer
+	devices := fakeDevice.New(0, fakeDevice.Constant(time.Second))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	cfg := testConfig(addr)
+	cfg.Sources = []config.Source{{ID: 1, MaxBuffered: 3}, {ID: 2}}
+	cfg.Admission.MaxInFlight = 5
+	ep, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	admitted := func(source uint) int {
+		var wg sync.WaitGroup
+		var ok atomic.Int32
+		for i := 1; i <= posts; i++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"source_id": %d, "test_number": %d}`, source, n)
+				resp, err := http.Post(srv.URL+"/test", "application/json", bytes.NewBufferString(body))
+				if err != nil {
+					t.Errorf("POST /test: %v", err)
+					return
+				}
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					ok.Add(1)
+				}
+			}(i)
+		}
+		wg.Wait()
+		return int(ok.Load())
+	}
+
+	if got := admitted(1); got != 3 {
+		t.Errorf("admitted %d concurrent tests of source 1, want max_buffered 3", got)
+	}
+	if got := admitted(2); got != 2 {
+		t.Errorf("admitted %d concurrent tests of source 2, want the 2 left of max_in_flight 5", got)
+	}
+	if buffered, _ := ep.(*entrypoint).st.ListTests(); len(buffered) != 5 {
+		t.Errorf("buffer holds %d tests, want 5", len(buffered))
+	}
+}
+
+func TestPostTestRejected(t *testing.T) {
+	devices := fakeDevice.New(1, fakeDevice.Constant(time.Second))
+	addr, stop, err := devices.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("start fake DeviceService: %v", err)
+	}
+	defer stop()
+
+	cfg := testConfig(addr)
+	cfg.CycleBufferConfig = config.CycleBufferConfig{MaxSize: 1, EvictionPolicy: "reject"}
+	ep, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer ep.Shutdown(context.Background())
+
+	srv := httptest.NewServer(ep.(*entrypoint).router)
+	defer srv.Close()
+
+	// the first test takes the only device, the second the only position
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusConflict} {
+		resp, err := http.Post(srv.URL+"/test", "application/json", bytes.NewBufferString(fmt.Sprintf(`{"source_id": 1, "test_number": %d}`, i+1)))
+		if err != nil {
+			t.Fatalf("POST /test: %v", err)
+		}
+		var got test.AdmitResponse
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("test %d = %d %+v, want %d", i+1, resp.StatusCode, got, want)
+		}
+		if want == http.StatusConflict && (got.Status != "rejected" || got.Result.Outcome != test.OutcomeRejected) {
+			t.Errorf("rejected test = %+v, want status rejected", got)
+		}
+	}
+}
+
+func TestNewFailsAfterOpening(t *testing.T) {
+	cfg := testConfig("127.0.0.1:1")
+	// storage, Kafka producer and gRPC client are open when this is checked
+	cfg.DispatchConfig.BufferPolicy = "lottery"
+
+	if ep, err := New(cfg); err == nil {
+		ep.Shutdown(context.Background())
+		t.Fatalf("New accepted an unknown buffer policy")
+	}
+}
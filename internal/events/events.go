@@ -0,0 +1,158 @@
+// Package events publishes a typed Kafka event for every state change of a
+// test request or a device.
+package events
+
+import (
+	"Dispatcher/internal/dispatcher"
+	"Dispatcher/internal/http-server/handlers/test"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Version is the version of the Event envelope. It changes only when a field
+// is removed or changes its meaning.
+const Version = 1
+
+const (
+	RequestReceived     = "request_received"
+	RequestBuffered     = "request_buffered"
+	RequestEvicted      = "request_evicted"
+	RequestRejected     = "request_rejected"
+	RequestDispatched   = "request_dispatched"
+	RequestSendFailed   = "request_send_failed"
+	RequestDeadLettered = "request_dead_lettered"
+	RequestCompleted    = "request_completed"
+	DeviceBusy          = "device_busy"
+	DeviceFree          = "device_free"
+)
+
+var requestTypes = map[string]string{
+	test.StateReceived:     RequestReceived,
+	test.StateBuffered:     RequestBuffered,
+	test.StateEvicted:      RequestEvicted,
+	test.StateRejected:     RequestRejected,
+	test.StateDispatched:   RequestDispatched,
+	test.StateSendFailed:   RequestSendFailed,
+	test.StateDeadLettered: RequestDeadLettered,
+	test.StateCompleted:    RequestCompleted,
+}
+
+// Event is the envelope of every message on the events topic.
+type Event struct {
+	Version int `json:"version"`
+	// ID is set for events relayed from the outbox. The same event may be
+	// delivered again after a crash, consumers deduplicate by ID.
+	ID   int64  `json:"id,omitempty"`
+	Type string `json:"type"`
+	// At is when the change happened, EmittedAt when the event was produced.
+	At        time.Time `json:"at"`
+	EmittedAt time.Time `json:"emitted_at"`
+
+	SourceID      *uint  `json:"source_id,omitempty"`
+	RequestNumber *uint  `json:"request_number,omitempty"`
+	Pos           *int64 `json:"pos,omitempty"`
+	DeviceID      *int32 `json:"device_id,omitempty"`
+	Detail        string `json:"detail,omitempty"`
+}
+
+// Producer is the part of the Kafka producer the publisher uses.
+type Producer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+// Publisher turns buffer transitions and registry changes into events.
+type Publisher struct {
+	log      *slog.Logger
+	producer Producer
+	topic    string
+	now      func() time.Time
+}
+
+func NewPublisher(log *slog.Logger, producer Producer, topic string) *Publisher {
+	return &Publisher{
+		log:      log.With(slog.String("component", "events.Publisher")),
+		producer: producer,
+		topic:    topic,
+		now:      time.Now,
+	}
+}
+
+// FromTransition returns the event of a request transition and its message key.
+func FromTransition(sourceID, testNumber uint, tr test.Transition) (string, Event) {
+	typ, ok := requestTypes[tr.State]
+	if !ok {
+		typ = "request_" + tr.State
+	}
+
+	return RequestKey(sourceID, testNumber), Event{
+		Version:       Version,
+		Type:          typ,
+		At:            tr.At,
+		SourceID:      &sourceID,
+		RequestNumber: &testNumber,
+		Pos:           tr.Pos,
+		DeviceID:      tr.DeviceID,
+		Detail:        tr.Detail,
+	}
+}
+
+// RequestKey is the message key of request events, all events of one request
+// land in the same partition.
+func RequestKey(sourceID, testNumber uint) string {
+	return fmt.Sprintf("%d/%d", sourceID, testNumber)
+}
+
+// Observe implements test.TransitionObserver.
+func (p *Publisher) Observe(sourceID, testNumber uint, tr test.Transition) {
+	p.publish(FromTransition(sourceID, testNumber, tr))
+}
+
+// FromDevice returns the event of a registry change and its message key. A
+// device that became free has no time of its own, now is used.
+func FromDevice(st dispatcher.DeviceState, now time.Time) (string, Event) {
+	ev := Event{
+		Version:  Version,
+		Type:     DeviceFree,
+		At:       now,
+		DeviceID: &st.ID,
+	}
+	if st.Busy {
+		ev.Type = DeviceBusy
+		ev.At = st.BusySince
+	}
+	if st.Current != nil {
+		ev.SourceID = &st.Current.SourceID
+		ev.RequestNumber = &st.Current.TestNumber
+	}
+
+	return fmt.Sprintf("device/%d", st.ID), ev
+}
+
+// ObserveDevice implements dispatcher.DeviceObserver.
+func (p *Publisher) ObserveDevice(st dispatcher.DeviceState) {
+	p.publish(FromDevice(st, p.now()))
+}
+
+func (p *Publisher) publish(key string, ev Event) {
+	ev.Version = Version
+	ev.EmittedAt = p.now()
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		p.log.Error("failed to marshal event", slog.Any("error", err))
+		return
+	}
+
+	err = p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
+		Key:            []byte(key),
+		Value:          data,
+	}, nil)
+	if err != nil {
+		p.log.Error("failed to send event to kafka", slog.String("type", ev.Type), slog.Any("error", err))
+	}
+}
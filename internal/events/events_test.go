@@ -0,0 +1,115 @@
+package events
+
+import (
+	"Dispatcher/internal/dispatcher"
+	"Dispatcher/internal/http-server/handlers/test"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type fakeProducer struct {
+	messages []*kafka.Message
+}
+
+func (p *fakeProducer) Produce(msg *kafka.Message, _ chan kafka.Event) error {
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func (p *fakeProducer) events(t *testing.T) []Event {
+	t.Helper()
+
+	var evs []Event
+	for _, msg := range p.messages {
+		var ev Event
+		if err := json.Unmarshal(msg.Value, &ev); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func newTestPublisher() (*Publisher, *fakeProducer) {
+	producer := &fakeProducer{}
+	p := NewPublisher(slog.New(slog.NewTextHandler(io.Discard, nil)), producer, "events")
+	p.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC) }
+	return p, producer
+}
+
+func TestPublisherRequestEvents(t *testing.T) {
+	p, producer := newTestPublisher()
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pos := int64(3)
+	dev := int32(7)
+	p.Observe(1, 2, test.Transition{State: test.StateBuffered, Pos: &pos, At: at})
+	p.Observe(1, 2, test.Transition{State: test.StateDispatched, Pos: &pos, DeviceID: &dev, At: at})
+
+	evs := producer.events(t)
+	if len(evs) != 2 {
+		t.Fatalf("published %d events, want 2", len(evs))
+	}
+
+	ev := evs[0]
+	if ev.Version != Version || ev.Type != RequestBuffered || !ev.At.Equal(at) || ev.EmittedAt.IsZero() {
+		t.Errorf("buffered event = %+v", ev)
+	}
+	if ev.SourceID == nil || *ev.SourceID != 1 || ev.RequestNumber == nil || *ev.RequestNumber != 2 || ev.Pos == nil || *ev.Pos != 3 {
+		t.Errorf("buffered event = %+v, want 1/2 at pos 3", ev)
+	}
+	if ev.DeviceID != nil {
+		t.Errorf("buffered event has device %d", *ev.DeviceID)
+	}
+
+	if ev := evs[1]; ev.Type != RequestDispatched || ev.DeviceID == nil || *ev.DeviceID != 7 {
+		t.Errorf("dispatched event = %+v, want device 7", ev)
+	}
+	if key := string(producer.messages[0].Key); key != "1/2" {
+		t.Errorf("message key = %q, want 1/2", key)
+	}
+}
+
+func TestPublisherDeviceEvents(t *testing.T) {
+	p, producer := newTestPublisher()
+
+	registry := dispatcher.NewRegistry(time.Hour)
+	registry.Subscribe(p)
+
+	registry.Acquire(4, test.TestRequest{SourceID: 1, TestNumber: 9})
+	registry.Release(4, test.TestRequest{SourceID: 1, TestNumber: 9})
+
+	evs := producer.events(t)
+	if len(evs) != 2 {
+		t.Fatalf("published %d events, want 2", len(evs))
+	}
+	for i, typ := range []string{DeviceBusy, DeviceFree} {
+		ev := evs[i]
+		if ev.Type != typ || ev.DeviceID == nil || *ev.DeviceID != 4 || ev.RequestNumber == nil || *ev.RequestNumber != 9 {
+			t.Errorf("event %d = %+v, want %s of device 4 with 1/9", i, ev, typ)
+		}
+	}
+}
+
+func TestHubDropsForSlowWatchers(t *testing.T) {
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	evs, cancel := hub.Watch(1)
+
+	hub.Observe(1, 1, test.Transition{State: test.StateReceived})
+	hub.Observe(1, 1, test.Transition{State: test.StateBuffered})
+
+	if ev := <-evs; ev.Type != RequestReceived || ev.Version != Version {
+		t.Errorf("watched event = %+v, want request_received", ev)
+	}
+
+	cancel()
+	if _, ok := <-evs; ok {
+		t.Errorf("channel has events after the second one was dropped")
+	}
+	cancel()
+}
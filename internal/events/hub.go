@@ -0,0 +1,72 @@
+package events
+
+import (
+	"Dispatcher/internal/dispatcher"
+	"Dispatcher/internal/http-server/handlers/test"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Hub fans events out to in-process watchers. A watcher that doesn't keep up
+// loses events instead of slowing the dispatcher down.
+type Hub struct {
+	log *slog.Logger
+
+	mu       sync.Mutex
+	watchers map[chan Event]struct{}
+}
+
+func NewHub(log *slog.Logger) *Hub {
+	return &Hub{
+		log:      log.With(slog.String("component", "events.Hub")),
+		watchers: make(map[chan Event]struct{}),
+	}
+}
+
+// Watch returns a channel of events buffered for size events and a function
+// that stops watching and closes the channel.
+func (h *Hub) Watch(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+
+	h.mu.Lock()
+	h.watchers[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.watchers, ch)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Observe implements test.TransitionObserver.
+func (h *Hub) Observe(sourceID, testNumber uint, tr test.Transition) {
+	_, ev := FromTransition(sourceID, testNumber, tr)
+	h.broadcast(ev)
+}
+
+// ObserveDevice implements dispatcher.DeviceObserver.
+func (h *Hub) ObserveDevice(st dispatcher.DeviceState) {
+	_, ev := FromDevice(st, time.Now())
+	h.broadcast(ev)
+}
+
+func (h *Hub) broadcast(ev Event) {
+	ev.EmittedAt = time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.watchers {
+		select {
+		case ch <- ev:
+		default:
+			h.log.Warn("event watcher is too slow, dropping event", slog.String("type", ev.Type))
+		}
+	}
+}
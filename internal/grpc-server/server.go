@@ -0,0 +1,285 @@
+// Package grpcserver is the Dispatcher's own gRPC API. The service is
+// described in proto/dispatcher/dispatcher.proto, dispatcherpb holds the
+// generated code.
+package grpcserver
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=Dispatcher --go-grpc_out=../.. --go-grpc_opt=module=Dispatcher dispatcher/dispatcher.proto
+
+import (
+	"Dispatcher/internal/events"
+	"Dispatcher/internal/grpc-server/dispatcherpb"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchBuffer is how many events a slow WatchEvents client may fall behind.
+const watchBuffer = 256
+
+// Admitter runs a request through admission, test.Handler implements it.
+type Admitter interface {
+	Admit(log *slog.Logger, req test.TestRequest) (test.AdmitResult, error)
+}
+
+// Buffer is the part of the circular buffer GetBufferState reads.
+type Buffer interface {
+	CheckAvailableSpace() (int64, error)
+	GetMaxSize() int64
+	ListTests() ([]test.BufferedTest, error)
+}
+
+type Watcher interface {
+	Watch(size int) (<-chan events.Event, func())
+}
+
+// Service implements dispatcherpb.DispatcherServer.
+type Service struct {
+	dispatcherpb.UnimplementedDispatcherServer
+
+	log      *slog.Logger
+	admitter Admitter
+	buffer   Buffer
+	watcher  Watcher
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewService(log *slog.Logger, admitter Admitter, buffer Buffer, watcher Watcher) *Service {
+	return &Service{
+		log:      log.With(slog.String("component", "grpcserver.Service")),
+		admitter: admitter,
+		buffer:   buffer,
+		watcher:  watcher,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (s *Service) SubmitTest(_ context.Context, req *dispatcherpb.TestRequest) (*dispatcherpb.SubmitTestResponse, error) {
+	resp, code := s.submit(req)
+	if code != codes.OK {
+		return nil, status.Error(code, resp.Message)
+	}
+	return resp, nil
+}
+
+func (s *Service) SubmitTests(stream dispatcherpb.Dispatcher_SubmitTestsServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp, _ := s.submit(req)
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// submit admits req. The code tells SubmitTest which status a failed
+// response maps to.
+func (s *Service) submit(pbReq *dispatcherpb.TestRequest) (*dispatcherpb.SubmitTestResponse, codes.Code) {
+	req := &test.TestRequest{
+		SourceID:   uint(pbReq.GetSourceId()),
+		TestNumber: uint(pbReq.GetTestNumber()),
+	}
+	log := s.log.With(slog.Any("request", *req))
+
+	resp := &dispatcherpb.SubmitTestResponse{
+		SourceId:   pbReq.GetSourceId(),
+		TestNumber: pbReq.GetTestNumber(),
+	}
+	if err := req.Validate(); err != nil {
+		resp.Status = "error"
+		resp.Message = err.Error()
+		return resp, codes.InvalidArgument
+	}
+
+	result, err := s.admitter.Admit(log, *req)
+	var quotaErr *test.QuotaError
+	if errors.As(err, &quotaErr) {
+		log.Warn("test refused by admission control", slog.Any("error", err))
+		resp.Status = "error"
+		resp.Message = err.Error()
+		return resp, codes.ResourceExhausted
+	}
+	if errors.Is(err, test.ErrUnknownSource) {
+		log.Warn("test from unknown source refused", slog.Any("error", err))
+		resp.Status = "error"
+		resp.Message = err.Error()
+		return resp, codes.PermissionDenied
+	}
+	if err != nil {
+		log.Error("failed to admit test", slog.Any("error", err))
+		resp.Status = "error"
+		resp.Message = "Failed to handle test"
+		return resp, codes.Unavailable
+	}
+
+	resp.Status = "success"
+	if result.Outcome == test.OutcomeRejected {
+		resp.Status = "rejected"
+	}
+	resp.Message = result.String()
+	resp.Result = admitResultToProto(result)
+	return resp, codes.OK
+}
+
+func admitResultToProto(r test.AdmitResult) *dispatcherpb.AdmitResult {
+	pb := &dispatcherpb.AdmitResult{
+		SourceId:   uint32(r.SourceID),
+		TestNumber: uint32(r.TestNumber),
+		Outcome:    r.Outcome,
+		DeviceId:   r.DeviceID,
+		Pos:        r.Pos,
+	}
+	if r.Evicted != nil {
+		pb.Evicted = &dispatcherpb.TestRequest{
+			SourceId:   uint32(r.Evicted.SourceID),
+			TestNumber: uint32(r.Evicted.TestNumber),
+		}
+	}
+	return pb
+}
+
+func (s *Service) GetBufferState(context.Context, *emptypb.Empty) (*dispatcherpb.BufferState, error) {
+	available, err := s.buffer.CheckAvailableSpace()
+	if err != nil {
+		s.log.Error("failed to check available space", slog.Any("error", err))
+		return nil, status.Error(codes.Unavailable, "failed to check available space")
+	}
+
+	buffered, err := s.buffer.ListTests()
+	if err != nil {
+		s.log.Error("failed to list buffered tests", slog.Any("error", err))
+		return nil, status.Error(codes.Unavailable, "failed to list buffered tests")
+	}
+
+	state := &dispatcherpb.BufferState{
+		MaxSize:   s.buffer.GetMaxSize(),
+		Available: available,
+		Tests:     make([]*dispatcherpb.BufferedTest, 0, len(buffered)),
+	}
+	for _, t := range buffered {
+		state.Tests = append(state.Tests, &dispatcherpb.BufferedTest{
+			Pos:         t.Pos,
+			SourceId:    uint32(t.SourceID),
+			TestNumber:  uint32(t.TestNumber),
+			ArrivalTime: timestamppb.New(t.ArrivalTime),
+			Attempts:    int32(t.Attempts),
+			InFlight:    t.InFlight,
+		})
+	}
+
+	return state, nil
+}
+
+func (s *Service) WatchEvents(req *dispatcherpb.WatchEventsRequest, stream dispatcherpb.Dispatcher_WatchEventsServer) error {
+	types := make(map[string]bool, len(req.GetTypes()))
+	for _, t := range req.GetTypes() {
+		types[t] = true
+	}
+
+	evs, cancel := s.watcher.Watch(watchBuffer)
+	defer cancel()
+
+	for {
+		select {
+		case <-s.stop:
+			return status.Error(codes.Unavailable, "dispatcher is shutting down")
+		case <-stream.Context().Done():
+			return nil
+		case ev := <-evs:
+			if len(types) > 0 && !types[ev.Type] {
+				continue
+			}
+			if err := stream.Send(eventToProto(ev)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func eventToProto(ev events.Event) *dispatcherpb.Event {
+	pb := &dispatcherpb.Event{
+		Version:   int32(ev.Version),
+		Id:        ev.ID,
+		Type:      ev.Type,
+		At:        timestamppb.New(ev.At),
+		EmittedAt: timestamppb.New(ev.EmittedAt),
+		Pos:       ev.Pos,
+		DeviceId:  ev.DeviceID,
+		Detail:    ev.Detail,
+	}
+	if ev.SourceID != nil {
+		id := uint32(*ev.SourceID)
+		pb.SourceId = &id
+	}
+	if ev.RequestNumber != nil {
+		n := uint32(*ev.RequestNumber)
+		pb.RequestNumber = &n
+	}
+	return pb
+}
+
+// close ends the WatchEvents streams, they would keep a graceful stop waiting.
+func (s *Service) close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Server serves the Dispatcher service.
+type Server struct {
+	srv *grpc.Server
+	svc *Service
+}
+
+func NewServer(svc *Service) *Server {
+	srv := grpc.NewServer()
+	dispatcherpb.RegisterDispatcherServer(srv, svc)
+
+	return &Server{srv: srv, svc: svc}
+}
+
+// Serve accepts connections on lis until Shutdown. It returns nil after Shutdown.
+func (s *Server) Serve(lis net.Listener) error {
+	if err := s.srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown ends the event streams and waits for in-flight calls. When ctx is
+// done first the remaining calls are cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.svc.close()
+
+	done := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.srv.Stop()
+		return ctx.Err()
+	}
+}
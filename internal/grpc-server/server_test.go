@@ -0,0 +1,200 @@
+package grpcserver
+
+import (
+	"Dispatcher/internal/events"
+	"Dispatcher/internal/grpc-server/dispatcherpb"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeAdmitter admits everything except tests of source 99, whose admission
+// fails, of source 98, which is unknown, and of source 97, which is over its
+// quota.
+type fakeAdmitter struct {
+	mu       sync.Mutex
+	admitted []test.TestRequest
+}
+
+func (a *fakeAdmitter) Admit(_ *slog.Logger, req test.TestRequest) (test.AdmitResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if req.SourceID == 99 {
+		return test.AdmitResult{}, errors.New("storage is down")
+	}
+	if req.SourceID == 97 {
+		return test.AdmitResult{}, &test.QuotaError{SourceID: 97, Reason: "rate limit exceeded", RetryAfter: time.Second}
+	}
+	if req.SourceID == 98 {
+		return test.AdmitResult{}, fmt.Errorf("%w: 98", test.ErrUnknownSource)
+	}
+	a.admitted = append(a.admitted, req)
+	pos := int64(len(a.admitted))
+	return test.AdmitResult{SourceID: req.SourceID, TestNumber: req.TestNumber, Outcome: test.OutcomeBuffered, Pos: &pos}, nil
+}
+
+type fakeBuffer struct{}
+
+func (fakeBuffer) CheckAvailableSpace() (int64, error) { return 3, nil }
+func (fakeBuffer) GetMaxSize() int64                   { return 4 }
+func (fakeBuffer) ListTests() ([]test.BufferedTest, error) {
+	return []test.BufferedTest{{Pos: 2, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 7}, Attempts: 1}}, nil
+}
+
+func startServer(t *testing.T, admitter Admitter, hub *events.Hub) dispatcherpb.DispatcherClient {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := NewServer(NewService(log, admitter, fakeBuffer{}, hub))
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	cc, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	return dispatcherpb.NewDispatcherClient(cc)
+}
+
+func TestSubmitTest(t *testing.T) {
+	admitter := &fakeAdmitter{}
+	client := startServer(t, admitter, events.NewHub(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	resp, err := client.SubmitTest(context.Background(), &dispatcherpb.TestRequest{SourceId: 1, TestNumber: 2})
+	if err != nil {
+		t.Fatalf("SubmitTest: %v", err)
+	}
+	if resp.Status != "success" || resp.SourceId != 1 || resp.TestNumber != 2 || resp.Message != "buffered at position 1" {
+		t.Errorf("SubmitTest = %+v", resp)
+	}
+	if resp.GetResult().GetOutcome() != test.OutcomeBuffered || resp.GetResult().GetPos() != 1 {
+		t.Errorf("SubmitTest result = %+v, want buffered", resp.Result)
+	}
+	if len(admitter.admitted) != 1 {
+		t.Errorf("admitted %v, want one test", admitter.admitted)
+	}
+
+	_, err = client.SubmitTest(context.Background(), &dispatcherpb.TestRequest{SourceId: 99, TestNumber: 1})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("SubmitTest on failing admission = %v, want Unavailable", err)
+	}
+
+	_, err = client.SubmitTest(context.Background(), &dispatcherpb.TestRequest{SourceId: 97, TestNumber: 1})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("SubmitTest over quota = %v, want ResourceExhausted", err)
+	}
+
+	_, err = client.SubmitTest(context.Background(), &dispatcherpb.TestRequest{SourceId: 98, TestNumber: 1})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("SubmitTest from unknown source = %v, want PermissionDenied", err)
+	}
+
+	_, err = client.SubmitTest(context.Background(), &dispatcherpb.TestRequest{SourceId: 0, TestNumber: 1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SubmitTest without source = %v, want InvalidArgument", err)
+	}
+}
+
+func TestSubmitTests(t *testing.T) {
+	admitter := &fakeAdmitter{}
+	client := startServer(t, admitter, events.NewHub(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	stream, err := client.SubmitTests(context.Background())
+	if err != nil {
+		t.Fatalf("SubmitTests: %v", err)
+	}
+
+	reqs := []*dispatcherpb.TestRequest{{SourceId: 1, TestNumber: 1}, {SourceId: 99, TestNumber: 2}, {SourceId: 2, TestNumber: 3}, {SourceId: 2}}
+	want := []string{"success", "error", "success", "error"}
+	for i := range reqs {
+		if err := stream.Send(reqs[i]); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if resp.Status != want[i] || resp.TestNumber != reqs[i].TestNumber {
+			t.Errorf("response %d = %+v, want %s", i, resp, want[i])
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend: %v", err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("Recv after CloseSend = %v, want EOF", err)
+	}
+}
+
+func TestGetBufferState(t *testing.T) {
+	client := startServer(t, &fakeAdmitter{}, events.NewHub(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	state, err := client.GetBufferState(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("GetBufferState: %v", err)
+	}
+	if state.MaxSize != 4 || state.Available != 3 || len(state.Tests) != 1 || state.Tests[0].Pos != 2 || state.Tests[0].TestNumber != 7 {
+		t.Errorf("GetBufferState = %+v", state)
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	hub := events.NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	client := startServer(t, &fakeAdmitter{}, hub)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchEvents(ctx, &dispatcherpb.WatchEventsRequest{Types: []string{events.RequestDispatched}})
+	if err != nil {
+		t.Fatalf("WatchEvents: %v", err)
+	}
+
+	// the watcher is registered asynchronously, keep publishing until it sees one
+	received := make(chan *dispatcherpb.Event, 1)
+	go func() {
+		ev, err := stream.Recv()
+		if err == nil {
+			received <- ev
+		}
+	}()
+
+	device := int32(3)
+	for {
+		hub.Observe(1, 1, test.Transition{State: test.StateBuffered})
+		hub.Observe(1, 1, test.Transition{State: test.StateDispatched, DeviceID: &device})
+
+		select {
+		case ev := <-received:
+			if ev.Type != events.RequestDispatched || ev.DeviceId == nil || *ev.DeviceId != 3 {
+				t.Errorf("watched event = %+v, want dispatched to device 3", ev)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatalf("no event received")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
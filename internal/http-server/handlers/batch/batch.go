@@ -0,0 +1,125 @@
+package batch
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	Message string             `json:"message,omitempty"`
+	Status  string             `json:"status"`
+	Results []test.AdmitResult `json:"results"`
+}
+
+type BatchAdmitter interface {
+	AdmitBatch(log *slog.Logger, reqs []test.TestRequest) ([]test.AdmitResult, error)
+}
+
+// New handles POST /tests/batch. The tests are admitted in order and the
+// response holds one result per test.
+func New(log *slog.Logger, admitter BatchAdmitter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.batch.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var reqs []test.TestRequest
+		err := render.DecodeJSON(r.Body, &reqs)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{
+				Message: "Request body is empty",
+				Status:  "error",
+			})
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", "error", err)
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{
+				Message: "Failed to decode request body",
+				Status:  "error",
+			})
+
+			return
+		}
+		if len(reqs) == 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{
+				Message: "Batch is empty",
+				Status:  "error",
+			})
+
+			return
+		}
+		for i, req := range reqs {
+			if err := req.Validate(); err != nil {
+				render.Status(r, http.StatusBadRequest)
+				render.JSON(w, r, test.TestResponse{
+					Message: fmt.Sprintf("test %d: %v", i, err),
+					Status:  "error",
+				})
+
+				return
+			}
+		}
+
+		log.Info("batch decoded", slog.Int("tests", len(reqs)))
+
+		results, err := admitter.AdmitBatch(log, reqs)
+		var quotaErr *test.QuotaError
+		if errors.As(err, &quotaErr) {
+			log.Warn("batch refused by admission control", "error", err, slog.Int("admitted", len(results)))
+
+			w.Header().Set("Retry-After", quotaErr.RetryAfterSeconds())
+			render.Status(r, http.StatusTooManyRequests)
+			render.JSON(w, r, Response{
+				Message: err.Error(),
+				Status:  "error",
+				Results: results,
+			})
+
+			return
+		}
+		if errors.Is(err, test.ErrUnknownSource) {
+			log.Warn("batch with unknown source refused", "error", err)
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, test.TestResponse{
+				Message: err.Error(),
+				Status:  "error",
+			})
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to admit batch", "error", err, slog.Int("admitted", len(results)))
+
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, Response{
+				Message: "Failed to handle tests",
+				Status:  "error",
+				Results: results,
+			})
+
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Status:  "success",
+			Results: results,
+		})
+	}
+}
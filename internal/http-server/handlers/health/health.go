@@ -0,0 +1,62 @@
+package health
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/render"
+)
+
+// Checker is a dependency the service needs to be ready.
+type Checker interface {
+	Ready() error
+}
+
+// Detailer is a Checker with something to show besides its state.
+type Detailer interface {
+	Details() any
+}
+
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Details any    `json:"details,omitempty"`
+}
+
+type Response struct {
+	Status string  `json:"status"`
+	Checks []Check `json:"checks"`
+}
+
+// New handles GET /ready. It answers 503 when any of the checks fails.
+func New(checks map[string]Checker) http.HandlerFunc {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := Response{Status: "ok", Checks: make([]Check, 0, len(names))}
+
+		for _, name := range names {
+			checker := checks[name]
+			check := Check{Name: name, Status: "ok"}
+			if err := checker.Ready(); err != nil {
+				check.Status = "error"
+				check.Error = err.Error()
+				resp.Status = "error"
+			}
+			if d, ok := checker.(Detailer); ok {
+				check.Details = d.Details()
+			}
+			resp.Checks = append(resp.Checks, check)
+		}
+
+		if resp.Status != "ok" {
+			render.Status(r, http.StatusServiceUnavailable)
+		}
+		render.JSON(w, r, resp)
+	}
+}
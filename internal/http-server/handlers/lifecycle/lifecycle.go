@@ -0,0 +1,66 @@
+package lifecycle
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+type Response struct {
+	SourceID    uint              `json:"source_id"`
+	TestNumber  uint              `json:"test_number"`
+	Transitions []test.Transition `json:"transitions"`
+}
+
+type LifecycleGetter interface {
+	GetLifecycle(sourceID, testNumber uint) ([]test.Transition, error)
+}
+
+// New handles GET /tests/{source}/{number}.
+func New(log *slog.Logger, getter LifecycleGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.lifecycle.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		source, err := strconv.ParseUint(chi.URLParam(r, "source"), 10, 32)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{Message: "Invalid source id", Status: "error"})
+			return
+		}
+		number, err := strconv.ParseUint(chi.URLParam(r, "number"), 10, 32)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{Message: "Invalid test number", Status: "error"})
+			return
+		}
+
+		transitions, err := getter.GetLifecycle(uint(source), uint(number))
+		if err != nil {
+			log.Error("failed to get request lifecycle", "error", err)
+
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, test.TestResponse{Message: "Failed to get request lifecycle", Status: "error"})
+			return
+		}
+
+		if len(transitions) == 0 {
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, test.TestResponse{Message: "Test not found", Status: "error"})
+			return
+		}
+
+		render.JSON(w, r, Response{
+			SourceID:    uint(source),
+			TestNumber:  uint(number),
+			Transitions: transitions,
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package result
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// Request is what a device reports after it finished a test.
+type Request struct {
+	DeviceID   int32  `json:"device_id"`
+	SourceID   uint   `json:"source_id"`
+	TestNumber uint   `json:"test_number"`
+	Result     bool   `json:"result"`
+	DurationMs int64  `json:"duration_ms"`
+	Msg        string `json:"msg"`
+}
+
+// ResultStore records the completion and queues the result for UserService
+// in one transaction.
+type ResultStore interface {
+	RecordResult(r test.Result, tr test.Transition) error
+}
+
+// DeviceReleaser tells which test a device runs and frees it once the device
+// finished that test.
+type DeviceReleaser interface {
+	Current(id int32) (test.TestRequest, bool)
+	Release(id int32, req test.TestRequest) bool
+}
+
+// New handles POST /results. A report about the test the device is running
+// is stored together with the completion and forwarded to UserService later
+// from the result queue, then the device is freed in the registry and the
+// dispatcher woken up.
+func New(log *slog.Logger, store ResultStore, devices DeviceReleaser, notifier test.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.result.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var req Request
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty")
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{
+				Message: "Request body is empty",
+				Status:  "error",
+			})
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to decode request body", "error", err)
+
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{
+				Message: "Failed to decode request body",
+				Status:  "error",
+			})
+
+			return
+		}
+		if req.DurationMs < 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{
+				Message: "duration_ms must not be negative",
+				Status:  "error",
+			})
+
+			return
+		}
+
+		finished := test.TestRequest{SourceID: req.SourceID, TestNumber: req.TestNumber}
+		if err := finished.Validate(); err != nil || req.DeviceID == 0 {
+			msg := "device_id is required"
+			if err != nil {
+				msg = err.Error()
+			}
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, test.TestResponse{
+				Message: msg,
+				Status:  "error",
+			})
+
+			return
+		}
+
+		log.Info("device finished test", slog.Any("result", req))
+
+		if current, ok := devices.Current(req.DeviceID); !ok || current != finished {
+			log.Warn("device is not running the reported test", slog.Any("result", req))
+
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, test.TestResponse{
+				Message: "Device is not running this test",
+				Status:  "error",
+			})
+
+			return
+		}
+
+		duration := time.Duration(req.DurationMs) * time.Millisecond
+		err = store.RecordResult(test.Result{
+			TestRequest: finished,
+			DeviceID:    req.DeviceID,
+			Passed:      req.Result,
+			Duration:    duration,
+			Msg:         req.Msg,
+		}, test.Transition{
+			State:    test.StateCompleted,
+			DeviceID: &req.DeviceID,
+			Detail:   fmt.Sprintf("result=%t duration=%s %s", req.Result, duration, req.Msg),
+		})
+		if err != nil {
+			log.Error("failed to store result", "error", err)
+
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, test.TestResponse{
+				Message: "Failed to record result",
+				Status:  "error",
+			})
+
+			return
+		}
+
+		if !devices.Release(req.DeviceID, finished) {
+			// a concurrent report of the same test got here first
+			log.Warn("device was released meanwhile", slog.Any("result", req))
+		}
+		notifier.Notify()
+
+		render.JSON(w, r, test.TestResponse{
+			Message: "Result received",
+			Status:  "success",
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package stats
+
+import (
+	qstats "Dispatcher/internal/stats"
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+type Reporter interface {
+	Report() qstats.Report
+}
+
+// New handles GET /stats.
+func New(reporter Reporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, reporter.Report())
+	}
+}
@@ -1,31 +1,99 @@
 package test
 
 import (
-	grpcDevice "Dispatcher/internal/client/DeviceService/grpc"
 	"Dispatcher/internal/config"
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	device "github.com/BeedZiBood/protos/gen/go/DeviceService"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-chi/render"
 )
 
+// TrashTest is a test the buffer refused: evicted to make room or rejected
+// on arrival. It waits in the trash until its source was told about it.
 type TrashTest struct {
+	ID int64
 	TestRequest
+	// Reason is StateEvicted or StateRejected.
+	Reason      string
 	ArrivalTime time.Time
 	RemovalTime time.Time
 }
 
-type UserServiceTestResponse struct {
-	TestReq TestRequest `json:"test_req"`
-	Status  bool        `json:"status"`
+// Result is what a device reported about a finished test. It is queued until
+// UserService got it.
+type Result struct {
+	ID int64
+	TestRequest
+	DeviceID int32
+	Passed   bool
+	Duration time.Duration
+	Msg      string
+}
+
+type BufferedTest struct {
+	TestRequest
+	// Buffer is the name of the buffer that owns Pos.
+	Buffer      string
+	Pos         int64
+	ArrivalTime time.Time
+	Attempts    int
+	InFlight    bool
+	// Ready is false while the test is in flight or waits for a retry.
+	Ready bool
+}
+
+// Request lifecycle states.
+const (
+	StateReceived     = "received"
+	StateBuffered     = "buffered"
+	StateDispatched   = "dispatched"
+	StateEvicted      = "evicted"
+	StateRejected     = "rejected"
+	StateSendFailed   = "send_failed"
+	StateDeadLettered = "dead_lettered"
+	StateCompleted    = "completed"
+)
+
+// Transition is one step in the life of a test request.
+type Transition struct {
+	State    string    `json:"state"`
+	Pos      *int64    `json:"pos,omitempty"`
+	DeviceID *int32    `json:"device_id,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Record is a transition of one test request.
+type Record struct {
+	SourceID   uint
+	TestNumber uint
+	Transition
 }
+
+// SaveResult is what the buffer did with a test it was asked to save.
+type SaveResult struct {
+	// Rejected is set when the eviction policy refused the test, Pos and
+	// Evicted are unset then.
+	Rejected bool
+	Pos      int64
+	// Evicted is the test that left the full buffer to make room.
+	Evicted *TestRequest
+}
+
+// ErrTestNotFound is returned when there is no dispatchable test at a position.
+var ErrTestNotFound = errors.New("test not found in buffer")
+
 type KafkaData struct {
 	AvailableSpace int64 `json:"availableSpace"`
 	MaxSize        int64 `json:"maxSize"`
@@ -35,26 +103,178 @@ type TestRequest struct {
 	SourceID   uint `json:"source_id"`
 	TestNumber uint `json:"test_number"`
 }
+
+// ErrInvalidRequest is returned by Validate.
+var ErrInvalidRequest = errors.New("invalid test request")
+
+// ErrUnknownSource is returned by admission for a source that is not
+// configured while unknown sources are rejected.
+var ErrUnknownSource = errors.New("unknown source")
+
+// QuotaError is returned by admission when admission control refuses a test.
+// SourceID is 0 when the limit is not per source.
+type QuotaError struct {
+	SourceID uint
+	Reason   string
+	// RetryAfter is how long the caller should wait before trying again.
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	if e.SourceID == 0 {
+		return e.Reason
+	}
+	return fmt.Sprintf("source %d: %s", e.SourceID, e.Reason)
+}
+
+// RetryAfterSeconds is RetryAfter as the value of a Retry-After header:
+// whole seconds rounded up, at least one.
+func (e *QuotaError) RetryAfterSeconds() string {
+	return strconv.FormatInt(max(1, int64(math.Ceil(e.RetryAfter.Seconds()))), 10)
+}
+
+// Validate checks that both numbers are set and fit the int32 fields of
+// DeviceService.
+func (req TestRequest) Validate() error {
+	switch {
+	case req.SourceID == 0:
+		return fmt.Errorf("%w: source_id is required", ErrInvalidRequest)
+	case req.TestNumber == 0:
+		return fmt.Errorf("%w: test_number is required", ErrInvalidRequest)
+	case req.SourceID > math.MaxInt32:
+		return fmt.Errorf("%w: source_id is out of range", ErrInvalidRequest)
+	case req.TestNumber > math.MaxInt32:
+		return fmt.Errorf("%w: test_number is out of range", ErrInvalidRequest)
+	}
+	return nil
+}
+
 type TestResponse struct {
 	Message string `json:"message"`
 	Status  string `json:"status"`
 }
 
+// AdmitResponse is the reply to POST /test.
+type AdmitResponse struct {
+	Message string      `json:"message"`
+	Status  string      `json:"status"`
+	Result  AdmitResult `json:"result"`
+}
+
 type Handler struct {
-	testStorage   TestCycleBuffer
-	grpcDevice    *grpcDevice.Client
-	kafkaProducer *kafka.Producer
-	Cfg           *config.Config
+	testStorage    TestCycleBuffer
+	grpcDevice     DeviceDispatcher
+	deviceSelector DeviceSelector
+	registry       DeviceRegistry
+	notifier       Notifier
+	kafkaProducer  KafkaProducer
+	sources        SourcePolicy
+	admission      AdmissionControl
+	Cfg            *config.Config
+
+	// admitMu keeps the quotas from being checked against a buffer another
+	// admission is about to change.
+	admitMu sync.Mutex
+}
+
+// SourcePolicy decides which sources may submit tests. sources.Table
+// implements it.
+type SourcePolicy interface {
+	Allowed(sourceID uint) bool
+}
+
+// AdmissionControl enforces the quotas of admission and returns a
+// *QuotaError when one is exceeded. admission.Controller implements it.
+type AdmissionControl interface {
+	// Allow takes a token of the rate limit of sourceID, given that it has
+	// buffered tests in the buffer and that the dispatcher holds inFlight
+	// tests in all, buffered or running on a device.
+	Allow(sourceID uint, buffered, inFlight int) error
+}
+
+// DeviceDispatcher is the DeviceService API the dispatcher needs.
+// grpcDevice.Client implements it.
+type DeviceDispatcher interface {
+	GetDeviceList(ctx context.Context) ([]*device.DeviceResponse, error)
+	SendTest(ctx context.Context, deviceId, sourceId, testNumber int32) error
+}
+
+type DeviceSelector interface {
+	Next(devices []*device.DeviceResponse) (*device.DeviceResponse, bool)
+}
+
+// DeviceRegistry is the dispatcher's own view of which devices are busy.
+type DeviceRegistry interface {
+	Free(listed []*device.DeviceResponse) []*device.DeviceResponse
+	Acquire(id int32, req TestRequest) bool
+	Abort(id int32)
+	// Busy returns how many devices are running a test.
+	Busy() int
+}
+
+type KafkaProducer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+// Notifier is woken up after a test may have been put into the buffer.
+type Notifier interface {
+	Notify()
 }
 
 type TestCycleBuffer interface {
 	CheckAvailableSpace() (int64, error)
 	GetMaxSize() int64
 	GetCurrId() int64
+	// SaveTest records test as received and buffers it in the same
+	// transaction, so a failed save leaves no trace in the lifecycle log.
 	SaveTest(test *TestRequest) error
-	GetTrashTest() (*TrashTest, error)
+	// SaveTests saves reqs in order with the same semantics as SaveTest, as
+	// one transaction, and reports what happened to each of them.
+	SaveTests(reqs []TestRequest) ([]SaveResult, error)
+	// PendingRefusals returns up to limit trash tests whose source was not
+	// notified yet, oldest first.
+	PendingRefusals(limit int) ([]TrashTest, error)
+	MarkRefusalsNotified(ids []int64) error
+	// RecordResult records tr for the test of r and queues r for UserService
+	// in the same transaction.
+	RecordResult(r Result, tr Transition) error
+	// PendingResults returns up to limit queued results, oldest first.
+	PendingResults(limit int) ([]Result, error)
+	MarkResultsSent(ids []int64) error
 	GetTest() (int64, int64, int64, error)
+	ListTests() ([]BufferedTest, error)
 	DeleteTest(pos int64) error
+	// MarkInFlight reserves the test for sending so that it is neither
+	// dispatched twice nor evicted meanwhile.
+	MarkInFlight(pos int64) error
+	// RetryTest returns an in-flight test to the buffer after a failed send.
+	// It becomes ready again after backoff. Once maxAttempts sends failed the
+	// test is moved to the dead letter table and dead is true.
+	RetryTest(pos int64, backoff time.Duration, maxAttempts int, reason string) (dead bool, err error)
+	// DispatchTest removes an in-flight test the device at deviceID accepted.
+	DispatchTest(pos int64, deviceID int32) error
+
+	LifecycleLog
+}
+
+// LifecycleLog keeps the state history of every test request. Buffer
+// transitions (received on save, buffered, evicted, rejected, send_failed,
+// dead_lettered, dispatched from the buffer) are written by the buffer itself.
+type LifecycleLog interface {
+	RecordTransition(sourceID, testNumber uint, tr Transition) error
+	// RecordTransitions stores several transitions at once, in order.
+	RecordTransitions(records []Record) error
+	// GetLifecycle returns the transitions in the order they happened.
+	GetLifecycle(sourceID, testNumber uint) ([]Transition, error)
+	// Subscribe registers o for every transition recorded afterwards. It has
+	// to be called before the buffer is used.
+	Subscribe(o TransitionObserver)
+}
+
+// TransitionObserver is told about every lifecycle transition once it is
+// stored. Observe must be quick and must not call back into the buffer.
+type TransitionObserver interface {
+	Observe(sourceID, testNumber uint, tr Transition)
 }
 
 func New(log *slog.Logger, handler *Handler) http.HandlerFunc {
@@ -70,6 +290,7 @@ func New(log *slog.Logger, handler *Handler) http.HandlerFunc {
 		if errors.Is(err, io.EOF) {
 			log.Error("request body is empty")
 
+			render.Status(r, http.StatusBadRequest)
 			render.JSON(w, r, TestResponse{
 				Message: "Request body is empty",
 				Status:  "error",
@@ -78,8 +299,9 @@ func New(log *slog.Logger, handler *Handler) http.HandlerFunc {
 			return
 		}
 		if err != nil {
-			log.Error("failed to decode request body", err)
+			log.Error("failed to decode request body", "error", err)
 
+			render.Status(r, http.StatusBadRequest)
 			render.JSON(w, r, TestResponse{
 				Message: "Failed to decode request body",
 				Status:  "error",
@@ -87,83 +309,359 @@ func New(log *slog.Logger, handler *Handler) http.HandlerFunc {
 
 			return
 		}
+		if err := req.Validate(); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, TestResponse{
+				Message: err.Error(),
+				Status:  "error",
+			})
+
+			return
+		}
 
 		log.Info("request body decoded", slog.Any("request", req))
 
-		availableSpace, err := handler.testStorage.CheckAvailableSpace()
+		result, err := handler.Admit(log, req)
+		var quotaErr *QuotaError
+		if errors.As(err, &quotaErr) {
+			log.Warn("test refused by admission control", "error", err)
+
+			w.Header().Set("Retry-After", quotaErr.RetryAfterSeconds())
+			render.Status(r, http.StatusTooManyRequests)
+			render.JSON(w, r, TestResponse{
+				Message: err.Error(),
+				Status:  "error",
+			})
+
+			return
+		}
+		if errors.Is(err, ErrUnknownSource) {
+			log.Warn("test from unknown source refused", "error", err)
+
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, TestResponse{
+				Message: err.Error(),
+				Status:  "error",
+			})
+
+			return
+		}
 		if err != nil {
-			log.Error("failed to check available space", err)
+			log.Error("failed to admit test", "error", err)
 
+			render.Status(r, http.StatusServiceUnavailable)
 			render.JSON(w, r, TestResponse{
-				Message: "Failed to check available space",
+				Message: "Failed to handle test",
 				Status:  "error",
 			})
 
 			return
 		}
-		log.Info("check available space", slog.Any("available space", availableSpace))
-		maxSize := handler.testStorage.GetMaxSize()
-		data := KafkaData{
-			AvailableSpace: availableSpace,
-			MaxSize:        maxSize,
-		}
-		if availableSpace == 0 {
-			log.Info("Send test to buffer and get trash test from trash_table")
-			err := handler.testStorage.SaveTest(&req)
-			if err != nil {
-				log.Error("failed to save test", err)
-			}
-			trashTest, err := handler.testStorage.GetTrashTest()
-			if err != nil {
-				log.Error("failed to get trash test", err)
+		if result.Outcome == OutcomeRejected {
+			// the buffer refused the test for good, retrying won't help
+			render.Status(r, http.StatusConflict)
+			render.JSON(w, r, AdmitResponse{
+				Message: result.String(),
+				Status:  "rejected",
+				Result:  result,
+			})
+
+			return
+		}
+
+		render.JSON(w, r, AdmitResponse{
+			Message: result.String(),
+			Status:  "success",
+			Result:  result,
+		})
+	}
+}
+
+// Admit runs req through admission: it is sent straight to a free device when
+// the buffer is empty and buffered otherwise, a full buffer applies the
+// eviction policy. Every entry point of test requests goes through Admit or
+// AdmitBatch. An error means the outcome of req was not stored and it may be
+// admitted again.
+func (handler *Handler) Admit(log *slog.Logger, req TestRequest) (AdmitResult, error) {
+	results, err := handler.AdmitBatch(log, []TestRequest{req})
+	if err != nil {
+		return AdmitResult{}, err
+	}
+	return results[0], nil
+}
+
+// Admission outcomes reported per test by Admit and AdmitBatch.
+const (
+	OutcomeDispatched = "dispatched"
+	OutcomeBuffered   = "buffered"
+	OutcomeRejected   = "rejected"
+)
+
+// AdmitResult is what admission did with one test.
+type AdmitResult struct {
+	SourceID   uint   `json:"source_id"`
+	TestNumber uint   `json:"test_number"`
+	Outcome    string `json:"outcome"`
+	DeviceID   *int32 `json:"device_id,omitempty"`
+	Pos        *int64 `json:"pos,omitempty"`
+	// Evicted is the test that left the full buffer to make room for this one.
+	Evicted *TestRequest `json:"evicted,omitempty"`
+}
+
+func (r AdmitResult) String() string {
+	switch {
+	case r.Outcome == OutcomeDispatched && r.DeviceID != nil:
+		return fmt.Sprintf("dispatched to device %d", *r.DeviceID)
+	case r.Outcome == OutcomeBuffered && r.Pos != nil && r.Evicted != nil:
+		return fmt.Sprintf("buffered at position %d, evicted request %d/%d", *r.Pos, r.Evicted.SourceID, r.Evicted.TestNumber)
+	case r.Outcome == OutcomeBuffered && r.Pos != nil:
+		return fmt.Sprintf("buffered at position %d", *r.Pos)
+	case r.Outcome == OutcomeRejected:
+		return "rejected, the buffer is full"
+	}
+	return r.Outcome
+}
+
+// AdmitBatch admits reqs in order with the same semantics as Admit. While the
+// buffer is empty the leading tests go straight to free devices; from the
+// first one that can't be sent on, the rest is saved to the buffer in one
+// transaction. On error the returned results cover the tests admitted so far.
+// A test from a source the SourcePolicy refuses fails the whole batch with
+// ErrUnknownSource before anything is recorded. From the first test admission
+// control refuses on, the tests are recorded as rejected and a *QuotaError is
+// returned with the results of the ones before it.
+func (handler *Handler) AdmitBatch(log *slog.Logger, reqs []TestRequest) ([]AdmitResult, error) {
+	return handler.admitBatch(log, reqs, true)
+}
+
+// TryAdmit is Admit for callers that keep a test admission control refused
+// and submit it again later, like the Kafka consumer. The refusal is not
+// recorded, so the test is received once, when it is finally admitted.
+func (handler *Handler) TryAdmit(log *slog.Logger, req TestRequest) (AdmitResult, error) {
+	results, err := handler.admitBatch(log, []TestRequest{req}, false)
+	if err != nil {
+		return AdmitResult{}, err
+	}
+	return results[0], nil
+}
+
+// admitBatch implements AdmitBatch and TryAdmit. Quotas are checked before
+// anything is recorded; the tests admission control refused are only recorded
+// when recordRefusals is set. With admission control, admissions run one at a
+// time from the quota check until the tests are saved.
+func (handler *Handler) admitBatch(log *slog.Logger, reqs []TestRequest, recordRefusals bool) ([]AdmitResult, error) {
+	for _, req := range reqs {
+		if handler.sources != nil && !handler.sources.Allowed(req.SourceID) {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownSource, req.SourceID)
+		}
+	}
+
+	var quotaErr error
+	if handler.admission != nil {
+		handler.admitMu.Lock()
+		defer handler.admitMu.Unlock()
+
+		buffered, err := handler.testStorage.ListTests()
+		if err != nil {
+			return nil, fmt.Errorf("list buffered tests: %w", err)
+		}
+		var allowed int
+		allowed, quotaErr = handler.checkQuotas(reqs, buffered)
+		if quotaErr != nil {
+			if recordRefusals {
+				handler.refuse(log, reqs[allowed:], quotaErr)
 			}
-			log.Info("trash test", slog.Any("test", trashTest))
-			response := UserServiceTestResponse{
-				TestReq: req,
-				Status:  false,
+			reqs = reqs[:allowed]
+			if len(reqs) == 0 {
+				return nil, quotaErr
 			}
-			sendTest(&response, log)
-			sendToKafka(&data, handler, log)
-		} else if availableSpace == maxSize {
-			log.Info("Trying to send test to device, if it's imposible, try to send test to buffer")
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-
-			devices, err := handler.grpcDevice.GetDeviceList(ctx)
-			if err != nil {
-				log.Error(err.Error())
-				log.Info("Save test in buffer")
-				handler.testStorage.SaveTest(&req)
-			} else {
-				log.Debug("getting list of free devices", slog.Any("num of devices", len(devices)), slog.Any("available devices", devices))
-				if len(devices) > 0 {
-					log.Debug("try to send test", slog.Any("device", devices[0]))
-					handler.grpcDevice.SendTest(ctx, devices[0].DeviceId, int32(req.SourceID), int32(req.TestNumber))
-				} else {
-					err := handler.testStorage.SaveTest(&req)
-					if err != nil {
-						log.Error("failed to save test", err)
-					}
-				}
-
-				cancel()
+		}
+	}
+
+	availableSpace, err := handler.testStorage.CheckAvailableSpace()
+	if err != nil {
+		return nil, fmt.Errorf("check available space: %w", err)
+	}
+	maxSize := handler.testStorage.GetMaxSize()
+	data := KafkaData{
+		AvailableSpace: availableSpace,
+		MaxSize:        maxSize,
+	}
+
+	defer handler.notifier.Notify()
+
+	results := make([]AdmitResult, 0, len(reqs))
+	if availableSpace == maxSize {
+		results = handler.dispatchBatch(log, reqs)
+	}
+
+	rest := reqs[len(results):]
+	saved, err := handler.testStorage.SaveTests(rest)
+	if err != nil {
+		return results, fmt.Errorf("save tests: %w", err)
+	}
 
+	for i, res := range saved {
+		result := AdmitResult{SourceID: rest[i].SourceID, TestNumber: rest[i].TestNumber}
+		if res.Rejected {
+			result.Outcome = OutcomeRejected
+		} else {
+			result.Outcome = OutcomeBuffered
+			result.Pos = &saved[i].Pos
+			result.Evicted = res.Evicted
+			if res.Evicted == nil {
 				data.AvailableSpace--
-				sendToKafka(&data, handler, log)
 			}
-		} else if availableSpace < maxSize {
-			log.Info("Save test in buffer")
-			handler.testStorage.SaveTest(&req)
 		}
+		results = append(results, result)
+	}
 
-		render.JSON(w, r, TestResponse{
-			Message: "Data received successfully",
-			Status:  "success",
+	sendToKafka(&data, handler, log)
+	return results, quotaErr
+}
+
+// checkQuotas runs reqs through the quotas in order and returns how many of
+// them passed. err is the quota the first refused one exceeded.
+func (handler *Handler) checkQuotas(reqs []TestRequest, buffered []BufferedTest) (int, error) {
+	held := make(map[uint]int)
+	// a test being sent holds a device already
+	inFlight := handler.registry.Busy()
+	for _, t := range buffered {
+		held[t.SourceID]++
+		if !t.InFlight {
+			inFlight++
+		}
+	}
+
+	for i, req := range reqs {
+		if err := handler.admission.Allow(req.SourceID, held[req.SourceID], inFlight); err != nil {
+			return i, err
+		}
+		// the worst case, a test of the batch may be dispatched instead
+		held[req.SourceID]++
+		inFlight++
+	}
+	return len(reqs), nil
+}
+
+// refuse records the tests admission control refused as received and
+// rejected right away, the statistics count them as refusals.
+func (handler *Handler) refuse(log *slog.Logger, reqs []TestRequest, err error) {
+	records := make([]Record, 0, 2*len(reqs))
+	for _, req := range reqs {
+		records = append(records,
+			Record{
+				SourceID:   req.SourceID,
+				TestNumber: req.TestNumber,
+				Transition: Transition{State: StateReceived},
+			},
+			Record{
+				SourceID:   req.SourceID,
+				TestNumber: req.TestNumber,
+				Transition: Transition{State: StateRejected, Detail: err.Error()},
+			},
+		)
+	}
+	if err := handler.testStorage.RecordTransitions(records); err != nil {
+		log.Error("failed to record request lifecycle", "error", err)
+	}
+}
+
+// dispatchBatch sends the leading tests of reqs to free devices, one device
+// each, and stops at the first test it can't send. Only the tests sent are
+// recorded as received and dispatched, the rest is recorded by SaveTests.
+// The DeviceService calls share the configured gRPC client timeout.
+func (handler *Handler) dispatchBatch(log *slog.Logger, reqs []TestRequest) []AdmitResult {
+	ctx, cancel := context.WithTimeout(context.Background(), handler.Cfg.GRPCClient.Timeout)
+	defer cancel()
+
+	devices, err := handler.grpcDevice.GetDeviceList(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil
+	}
+	free := handler.registry.Free(devices)
+
+	var results []AdmitResult
+	var dispatched []Record
+	for _, req := range reqs {
+		dev, ok := handler.acquireDevice(free, req)
+		if !ok {
+			break
+		}
+		if err := handler.grpcDevice.SendTest(ctx, dev.DeviceId, int32(req.SourceID), int32(req.TestNumber)); err != nil {
+			log.Error("failed to send test, save it in buffer for retry", "error", err)
+			handler.registry.Abort(dev.DeviceId)
+			break
+		}
+
+		rest := free[:0:0]
+		for _, d := range free {
+			if d.DeviceId != dev.DeviceId {
+				rest = append(rest, d)
+			}
+		}
+		free = rest
+
+		deviceID := dev.DeviceId
+		dispatched = append(dispatched,
+			Record{
+				SourceID:   req.SourceID,
+				TestNumber: req.TestNumber,
+				Transition: Transition{State: StateReceived},
+			},
+			Record{
+				SourceID:   req.SourceID,
+				TestNumber: req.TestNumber,
+				Transition: Transition{State: StateDispatched, DeviceID: &deviceID},
+			},
+		)
+		results = append(results, AdmitResult{
+			SourceID:   req.SourceID,
+			TestNumber: req.TestNumber,
+			Outcome:    OutcomeDispatched,
+			DeviceID:   &deviceID,
 		})
 	}
+
+	if len(dispatched) > 0 {
+		if err := handler.testStorage.RecordTransitions(dispatched); err != nil {
+			log.Error("failed to record request lifecycle", "error", err)
+		}
+	}
+	return results
+}
+
+// acquireDevice picks a free device with the device selector and reserves it
+// in the registry.
+func (handler *Handler) acquireDevice(devices []*device.DeviceResponse, req TestRequest) (*device.DeviceResponse, bool) {
+	for len(devices) > 0 {
+		dev, ok := handler.deviceSelector.Next(devices)
+		if !ok {
+			return nil, false
+		}
+		if handler.registry.Acquire(dev.DeviceId, req) {
+			return dev, true
+		}
+
+		rest := devices[:0:0]
+		for _, d := range devices {
+			if d != dev {
+				rest = append(rest, d)
+			}
+		}
+		devices = rest
+	}
+	return nil, false
 }
 
 // sendToKafka сериализует данные теста и отправляет их в Kafka.
+// Only kept for old consumers, see config.KafkaProducer.DisableLegacyData.
 func sendToKafka(kafkaData *KafkaData, handler *Handler, log *slog.Logger) {
+	if handler.Cfg.KafkaProducer.DisableLegacyData {
+		return
+	}
+
 	data, err := json.Marshal(kafkaData)
 	if err != nil {
 		slog.Error("Ошибка сериализации в sendToKafka", "error", err)
@@ -182,28 +680,16 @@ func sendToKafka(kafkaData *KafkaData, handler *Handler, log *slog.Logger) {
 	}
 }
 
-func sendTest(test *UserServiceTestResponse, log *slog.Logger) {
-	data, err := json.Marshal(test)
-	if err != nil {
-		log.Error("Ошибка сериализации теста", "error", err)
-		return
-	}
-
-	resp, err := http.Post("http://localhost:8081/test", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		log.Error("Ошибка отправки POST запроса", "error", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	log.Info("Тест успешно отправлен", "status", resp.Status)
-}
-
-func NewHandler(ts TestCycleBuffer, gd *grpcDevice.Client, kafkaProducer *kafka.Producer, cfg *config.Config) *Handler {
+func NewHandler(ts TestCycleBuffer, gd DeviceDispatcher, ds DeviceSelector, dr DeviceRegistry, n Notifier, kafkaProducer KafkaProducer, sp SourcePolicy, ac AdmissionControl, cfg *config.Config) *Handler {
 	return &Handler{
-		testStorage:   ts,
-		grpcDevice:    gd,
-		kafkaProducer: kafkaProducer,
-		Cfg:           cfg,
+		testStorage:    ts,
+		grpcDevice:     gd,
+		deviceSelector: ds,
+		registry:       dr,
+		notifier:       n,
+		kafkaProducer:  kafkaProducer,
+		sources:        sp,
+		admission:      ac,
+		Cfg:            cfg,
 	}
 }
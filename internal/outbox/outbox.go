@@ -0,0 +1,112 @@
+// Package outbox relays events written to the outbox table together with the
+// buffer changes to Kafka.
+package outbox
+
+import (
+	"Dispatcher/internal/drain"
+	"Dispatcher/internal/events"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Message is an outbox row. Payload is a JSON encoded events.Event.
+type Message struct {
+	ID      int64
+	Key     string
+	Payload []byte
+}
+
+// Store is the outbox table.
+type Store interface {
+	// PendingOutbox returns up to limit unsent messages, oldest first.
+	PendingOutbox(limit int) ([]Message, error)
+	MarkOutboxSent(ids []int64) error
+}
+
+// Producer delivers messages and tells how many of them, from the start, made it.
+type Producer interface {
+	Deliver(ctx context.Context, msgs []*kafka.Message) (int, error)
+}
+
+// Relay publishes outbox messages in id order and marks them sent once Kafka
+// acknowledged them. A crash between the two sends the same event id again.
+// Stop relays what is left.
+type Relay struct {
+	*drain.Loop
+
+	log      *slog.Logger
+	store    Store
+	producer Producer
+	topic    string
+	timeout  time.Duration
+	now      func() time.Time
+}
+
+func NewRelay(log *slog.Logger, store Store, producer Producer, topic string, batchSize int, interval, timeout time.Duration) *Relay {
+	r := &Relay{
+		log:      log.With(slog.String("component", "outbox.Relay")),
+		store:    store,
+		producer: producer,
+		topic:    topic,
+		timeout:  timeout,
+		now:      time.Now,
+	}
+	r.Loop = drain.New(r.log, r.relayBatch, batchSize, interval)
+	return r
+}
+
+// relayBatch sends one batch and returns how many messages were marked sent.
+func (r *Relay) relayBatch(size int) (int, error) {
+	pending, err := r.store.PendingOutbox(size)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	msgs := make([]*kafka.Message, 0, len(pending))
+	for _, m := range pending {
+		msgs = append(msgs, r.message(m))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	delivered, deliverErr := r.producer.Deliver(ctx, msgs)
+	if delivered > 0 {
+		ids := make([]int64, 0, delivered)
+		for _, m := range pending[:delivered] {
+			ids = append(ids, m.ID)
+		}
+		if err := r.store.MarkOutboxSent(ids); err != nil {
+			return 0, err
+		}
+	}
+
+	return delivered, deliverErr
+}
+
+func (r *Relay) message(m Message) *kafka.Message {
+	payload := m.Payload
+
+	var ev events.Event
+	if err := json.Unmarshal(m.Payload, &ev); err != nil {
+		r.log.Error("outbox message is not an event, sending it as is", slog.Int64("id", m.ID), slog.Any("error", err))
+	} else {
+		ev.ID = m.ID
+		ev.EmittedAt = r.now()
+		if data, err := json.Marshal(ev); err == nil {
+			payload = data
+		}
+	}
+
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &r.topic, Partition: kafka.PartitionAny},
+		Key:            []byte(m.Key),
+		Value:          payload,
+		Headers:        []kafka.Header{{Key: "event_id", Value: []byte(strconv.FormatInt(m.ID, 10))}},
+	}
+}
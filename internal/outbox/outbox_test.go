@@ -0,0 +1,108 @@
+package outbox
+
+import (
+	"Dispatcher/internal/events"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type fakeStore struct {
+	mu   sync.Mutex
+	msgs []Message
+	sent map[int64]bool
+}
+
+func newFakeStore(n int) *fakeStore {
+	s := &fakeStore{sent: make(map[int64]bool)}
+	for i := 1; i <= n; i++ {
+		payload, _ := json.Marshal(events.Event{Version: events.Version, Type: events.RequestBuffered})
+		s.msgs = append(s.msgs, Message{ID: int64(i), Key: fmt.Sprintf("1/%d", i), Payload: payload})
+	}
+	return s
+}
+
+func (s *fakeStore) PendingOutbox(limit int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []Message
+	for _, m := range s.msgs {
+		if !s.sent[m.ID] && len(pending) < limit {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func (s *fakeStore) MarkOutboxSent(ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.sent[id] = true
+	}
+	return nil
+}
+
+// fakeProducer delivers up to budget messages and fails the rest.
+type fakeProducer struct {
+	budget    int
+	delivered []*kafka.Message
+}
+
+func (p *fakeProducer) Deliver(_ context.Context, msgs []*kafka.Message) (int, error) {
+	n := min(p.budget, len(msgs))
+	p.budget -= n
+	p.delivered = append(p.delivered, msgs[:n]...)
+	if n < len(msgs) {
+		return n, errors.New("broker down")
+	}
+	return n, nil
+}
+
+func newTestRelay(store Store, producer Producer) *Relay {
+	return NewRelay(slog.New(slog.NewTextHandler(io.Discard, nil)), store, producer, "events", 2, time.Hour, time.Second)
+}
+
+func TestRelayInOrder(t *testing.T) {
+	store := newFakeStore(5)
+	producer := &fakeProducer{budget: 3}
+	r := newTestRelay(store, producer)
+
+	r.Drain()
+
+	if len(store.sent) != 3 || !store.sent[1] || !store.sent[2] || !store.sent[3] {
+		t.Fatalf("sent = %v, want 1..3", store.sent)
+	}
+
+	producer.budget = 10
+	r.Drain()
+
+	if len(producer.delivered) != 5 {
+		t.Fatalf("delivered %d messages, want 5", len(producer.delivered))
+	}
+	for i, msg := range producer.delivered {
+		var ev events.Event
+		if err := json.Unmarshal(msg.Value, &ev); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		if ev.ID != int64(i+1) || ev.EmittedAt.IsZero() || ev.Type != events.RequestBuffered {
+			t.Errorf("event %d = %+v", i, ev)
+		}
+		if key := string(msg.Key); key != fmt.Sprintf("1/%d", i+1) {
+			t.Errorf("event %d key = %q", i, key)
+		}
+		if h := msg.Headers; len(h) != 1 || h[0].Key != "event_id" || string(h[0].Value) != fmt.Sprint(i+1) {
+			t.Errorf("event %d headers = %v", i, h)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package refusal tells sources about their tests the buffer refused: the
+// ones evicted to make room and the ones rejected on arrival.
+package refusal
+
+import (
+	httpclient "Dispatcher/internal/client/UserService/http"
+	"Dispatcher/internal/drain"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Store is the trash of the buffer. Refusals stay pending there until they
+// are marked notified, so they survive restarts and UserService outages.
+type Store interface {
+	PendingRefusals(limit int) ([]test.TrashTest, error)
+	MarkRefusalsNotified(ids []int64) error
+}
+
+// Sender delivers one refusal to UserService.
+type Sender interface {
+	SendRefusal(ctx context.Context, t test.TrashTest) error
+}
+
+// Notifier sends pending refusals in order. A refusal UserService turns down
+// for good is logged and dropped, any other failed send ends the round and
+// what is left is retried on the next tick. Stop makes one last round.
+type Notifier struct {
+	*drain.Loop
+
+	log     *slog.Logger
+	store   Store
+	sender  Sender
+	timeout time.Duration
+}
+
+func NewNotifier(log *slog.Logger, store Store, sender Sender, batchSize int, interval, timeout time.Duration) *Notifier {
+	n := &Notifier{
+		log:     log.With(slog.String("component", "refusal.Notifier")),
+		store:   store,
+		sender:  sender,
+		timeout: timeout,
+	}
+	n.Loop = drain.New(n.log, n.notifyBatch, batchSize, interval)
+	return n
+}
+
+// notifyBatch sends one batch and returns how many refusals were marked notified.
+func (n *Notifier) notifyBatch(size int) (int, error) {
+	pending, err := n.store.PendingRefusals(size)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	ids := make([]int64, 0, len(pending))
+	var sendErr error
+	for _, t := range pending {
+		if err := n.send(t); err != nil {
+			if !httpclient.Permanent(err) {
+				sendErr = err
+				break
+			}
+			n.log.Error("UserService refused the refusal, dropping it",
+				slog.Any("source_id", t.SourceID),
+				slog.Any("test_number", t.TestNumber),
+				slog.Any("error", err),
+			)
+		}
+		ids = append(ids, t.ID)
+	}
+
+	if len(ids) > 0 {
+		if err := n.store.MarkRefusalsNotified(ids); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), sendErr
+}
+
+func (n *Notifier) send(t test.TrashTest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	if err := n.sender.SendRefusal(ctx, t); err != nil {
+		return err
+	}
+
+	n.log.Info("source notified about refused test",
+		slog.Any("source_id", t.SourceID),
+		slog.Any("test_number", t.TestNumber),
+		slog.String("reason", t.Reason),
+	)
+	return nil
+}
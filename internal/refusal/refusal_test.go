@@ -0,0 +1,127 @@
+package refusal
+
+import (
+	httpclient "Dispatcher/internal/client/UserService/http"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	trash    []test.TrashTest
+	notified map[int64]bool
+}
+
+func newFakeStore(n int) *fakeStore {
+	s := &fakeStore{notified: make(map[int64]bool)}
+	for i := 1; i <= n; i++ {
+		s.trash = append(s.trash, test.TrashTest{
+			ID:          int64(i),
+			TestRequest: test.TestRequest{SourceID: 1, TestNumber: uint(i)},
+			Reason:      test.StateEvicted,
+		})
+	}
+	return s
+}
+
+func (s *fakeStore) PendingRefusals(limit int) ([]test.TrashTest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []test.TrashTest
+	for _, t := range s.trash {
+		if !s.notified[t.ID] && len(pending) < limit {
+			pending = append(pending, t)
+		}
+	}
+	return pending, nil
+}
+
+func (s *fakeStore) MarkRefusalsNotified(ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.notified[id] = true
+	}
+	return nil
+}
+
+// fakeSender accepts up to budget refusals and fails the rest. The test
+// numbers in bad are answered with 400.
+type fakeSender struct {
+	budget int
+	bad    map[uint]bool
+	sent   []uint
+}
+
+func (s *fakeSender) SendRefusal(_ context.Context, t test.TrashTest) error {
+	if s.bad[t.TestNumber] {
+		return &httpclient.StatusError{StatusCode: http.StatusBadRequest}
+	}
+	if s.budget == 0 {
+		return errors.New("UserService is down")
+	}
+	s.budget--
+	s.sent = append(s.sent, t.TestNumber)
+	return nil
+}
+
+func newTestNotifier(store Store, sender Sender) *Notifier {
+	return NewNotifier(slog.New(slog.NewTextHandler(io.Discard, nil)), store, sender, 2, time.Hour, time.Second)
+}
+
+func TestNotifierRetriesAfterFailure(t *testing.T) {
+	store := newFakeStore(5)
+	sender := &fakeSender{budget: 3}
+	n := newTestNotifier(store, sender)
+
+	n.Drain()
+
+	if len(sender.sent) != 3 {
+		t.Fatalf("sent %v, want the first 3", sender.sent)
+	}
+	pending, _ := store.PendingRefusals(10)
+	if len(pending) != 2 || pending[0].TestNumber != 4 {
+		t.Fatalf("pending after failure = %+v, want 1/4 and 1/5", pending)
+	}
+
+	sender.budget = 10
+	n.Drain()
+
+	want := []uint{1, 2, 3, 4, 5}
+	if len(sender.sent) != len(want) {
+		t.Fatalf("sent %v, want %v", sender.sent, want)
+	}
+	for i := range want {
+		if sender.sent[i] != want[i] {
+			t.Errorf("sent %v, want %v", sender.sent, want)
+			break
+		}
+	}
+	if pending, _ := store.PendingRefusals(10); len(pending) != 0 {
+		t.Errorf("pending after retry = %+v, want none", pending)
+	}
+}
+
+func TestNotifierDropsPermanentFailures(t *testing.T) {
+	store := newFakeStore(2)
+	sender := &fakeSender{budget: 10, bad: map[uint]bool{1: true}}
+	n := newTestNotifier(store, sender)
+
+	n.Drain()
+
+	if len(sender.sent) != 1 || sender.sent[0] != 2 {
+		t.Fatalf("sent %v, want 2 after 1 was refused with 400", sender.sent)
+	}
+	if pending, _ := store.PendingRefusals(10); len(pending) != 0 {
+		t.Errorf("pending = %+v, want none", pending)
+	}
+}
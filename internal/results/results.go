@@ -0,0 +1,101 @@
+// Package results forwards the results devices reported to UserService. The
+// result handler only queues them, so a slow or unavailable UserService
+// never holds up a device callback.
+package results
+
+import (
+	httpclient "Dispatcher/internal/client/UserService/http"
+	"Dispatcher/internal/drain"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Store is the result queue. Results stay pending there until they are
+// marked sent, so they survive restarts and UserService outages.
+type Store interface {
+	PendingResults(limit int) ([]test.Result, error)
+	MarkResultsSent(ids []int64) error
+}
+
+// Sender delivers one result to UserService, httpclient.Client implements it.
+type Sender interface {
+	SendResult(ctx context.Context, resp httpclient.Response) error
+}
+
+// Forwarder sends queued results in order. A result UserService turns down
+// for good is logged and dropped, any other failed send ends the round and
+// what is left is retried on the next tick. Stop makes one last round.
+type Forwarder struct {
+	*drain.Loop
+
+	log     *slog.Logger
+	store   Store
+	sender  Sender
+	timeout time.Duration
+}
+
+func NewForwarder(log *slog.Logger, store Store, sender Sender, batchSize int, interval, timeout time.Duration) *Forwarder {
+	f := &Forwarder{
+		log:     log.With(slog.String("component", "results.Forwarder")),
+		store:   store,
+		sender:  sender,
+		timeout: timeout,
+	}
+	f.Loop = drain.New(f.log, f.forwardBatch, batchSize, interval)
+	return f
+}
+
+// forwardBatch sends one batch and returns how many results were marked sent.
+func (f *Forwarder) forwardBatch(size int) (int, error) {
+	pending, err := f.store.PendingResults(size)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	ids := make([]int64, 0, len(pending))
+	var sendErr error
+	for _, r := range pending {
+		if err := f.send(r); err != nil {
+			if !httpclient.Permanent(err) {
+				sendErr = err
+				break
+			}
+			f.log.Error("UserService refused the result, dropping it",
+				slog.Any("source_id", r.SourceID),
+				slog.Any("test_number", r.TestNumber),
+				slog.Any("error", err),
+			)
+		}
+		ids = append(ids, r.ID)
+	}
+
+	if len(ids) > 0 {
+		if err := f.store.MarkResultsSent(ids); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), sendErr
+}
+
+func (f *Forwarder) send(r test.Result) error {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	err := f.sender.SendResult(ctx, httpclient.Response{
+		TestNum: int32(r.TestNumber),
+		Result:  r.Passed,
+		Msg:     r.Msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.log.Info("result forwarded to UserService",
+		slog.Any("source_id", r.SourceID),
+		slog.Any("test_number", r.TestNumber),
+	)
+	return nil
+}
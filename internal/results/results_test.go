@@ -0,0 +1,116 @@
+package results
+
+import (
+	httpclient "Dispatcher/internal/client/UserService/http"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	mu      sync.Mutex
+	results []test.Result
+	sent    map[int64]bool
+}
+
+func newFakeStore(n int) *fakeStore {
+	s := &fakeStore{sent: make(map[int64]bool)}
+	for i := 1; i <= n; i++ {
+		s.results = append(s.results, test.Result{
+			ID:          int64(i),
+			TestRequest: test.TestRequest{SourceID: 1, TestNumber: uint(i)},
+			Passed:      true,
+		})
+	}
+	return s
+}
+
+func (s *fakeStore) PendingResults(limit int) ([]test.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []test.Result
+	for _, r := range s.results {
+		if !s.sent[r.ID] && len(pending) < limit {
+			pending = append(pending, r)
+		}
+	}
+	return pending, nil
+}
+
+func (s *fakeStore) MarkResultsSent(ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.sent[id] = true
+	}
+	return nil
+}
+
+// fakeSender accepts up to budget results and fails the rest. The test
+// numbers in bad are answered with 400.
+type fakeSender struct {
+	budget int
+	bad    map[int32]bool
+	sent   []int32
+}
+
+func (s *fakeSender) SendResult(_ context.Context, resp httpclient.Response) error {
+	if s.bad[resp.TestNum] {
+		return &httpclient.StatusError{StatusCode: http.StatusBadRequest}
+	}
+	if s.budget == 0 {
+		return errors.New("UserService is down")
+	}
+	s.budget--
+	s.sent = append(s.sent, resp.TestNum)
+	return nil
+}
+
+func newTestForwarder(store Store, sender Sender) *Forwarder {
+	return NewForwarder(slog.New(slog.NewTextHandler(io.Discard, nil)), store, sender, 2, time.Hour, time.Second)
+}
+
+func TestForwarderRetriesAfterFailure(t *testing.T) {
+	store := newFakeStore(3)
+	sender := &fakeSender{budget: 1}
+	f := newTestForwarder(store, sender)
+
+	f.Drain()
+
+	if pending, _ := store.PendingResults(10); len(pending) != 2 || pending[0].TestNumber != 2 {
+		t.Fatalf("pending after failure = %+v, want 1/2 and 1/3", pending)
+	}
+
+	sender.budget = 10
+	f.Drain()
+
+	if len(sender.sent) != 3 || sender.sent[0] != 1 || sender.sent[2] != 3 {
+		t.Errorf("sent %v, want 1, 2, 3", sender.sent)
+	}
+	if pending, _ := store.PendingResults(10); len(pending) != 0 {
+		t.Errorf("pending after retry = %+v, want none", pending)
+	}
+}
+
+func TestForwarderDropsPermanentFailures(t *testing.T) {
+	store := newFakeStore(2)
+	sender := &fakeSender{budget: 10, bad: map[int32]bool{1: true}}
+	f := newTestForwarder(store, sender)
+
+	f.Drain()
+
+	if len(sender.sent) != 1 || sender.sent[0] != 2 {
+		t.Fatalf("sent %v, want 2 after 1 was refused with 400", sender.sent)
+	}
+	if pending, _ := store.PendingResults(10); len(pending) != 0 {
+		t.Errorf("pending = %+v, want none", pending)
+	}
+}
@@ -0,0 +1,150 @@
+// Package sources holds the configured test sources: their priority class,
+// weight and share of the buffer. Dispatch selection, eviction and admission
+// all read them from one Table.
+package sources
+
+import (
+	"Dispatcher/internal/config"
+	"fmt"
+	"math"
+)
+
+// Unknown source policies.
+const (
+	UnknownAccept = "accept"
+	UnknownReject = "reject"
+)
+
+type Source struct {
+	ID uint
+	// Priority is the class of the source, lower classes go first.
+	Priority int
+	Weight   int
+	// MaxShare is the part of the buffer the source may hold, 0 means all of it.
+	MaxShare float64
+	// MaxBuffered, Rate and Burst are the admission limits, 0 means none.
+	MaxBuffered int
+	Rate        float64
+	Burst       int
+}
+
+// Table answers questions about sources. A nil Table treats every source
+// alike, so the source number alone decides, as the buffer always did.
+type Table struct {
+	known   map[uint]Source
+	unknown Source
+	reject  bool
+}
+
+func New(list []config.Source, unknown config.UnknownSources) (*Table, error) {
+	const op = "sources.New"
+
+	t := &Table{known: make(map[uint]Source, len(list))}
+
+	switch unknown.Policy {
+	case "", UnknownAccept:
+	case UnknownReject:
+		t.reject = true
+	default:
+		return nil, fmt.Errorf("%s: unknown sources policy: %q", op, unknown.Policy)
+	}
+
+	var err error
+	t.unknown, err = source(config.Source{
+		Priority:    unknown.Priority,
+		Weight:      unknown.Weight,
+		MaxShare:    unknown.MaxShare,
+		MaxBuffered: unknown.MaxBuffered,
+		Rate:        unknown.Rate,
+		Burst:       unknown.Burst,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: unknown sources: %w", op, err)
+	}
+
+	for _, c := range list {
+		if _, ok := t.known[c.ID]; ok {
+			return nil, fmt.Errorf("%s: source %d is configured twice", op, c.ID)
+		}
+		s, err := source(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: source %d: %w", op, c.ID, err)
+		}
+		t.known[c.ID] = s
+	}
+
+	return t, nil
+}
+
+func source(c config.Source) (Source, error) {
+	switch {
+	case c.Weight < 0:
+		return Source{}, fmt.Errorf("weight %d is negative", c.Weight)
+	case c.MaxShare < 0 || c.MaxShare > 1:
+		return Source{}, fmt.Errorf("max_share %v is not within [0, 1]", c.MaxShare)
+	case c.MaxBuffered < 0:
+		return Source{}, fmt.Errorf("max_buffered %d is negative", c.MaxBuffered)
+	case c.Rate < 0:
+		return Source{}, fmt.Errorf("rate %v is negative", c.Rate)
+	case c.Burst < 0:
+		return Source{}, fmt.Errorf("burst %d is negative", c.Burst)
+	}
+
+	s := Source{
+		ID:          c.ID,
+		Priority:    c.Priority,
+		Weight:      c.Weight,
+		MaxShare:    c.MaxShare,
+		MaxBuffered: c.MaxBuffered,
+		Rate:        c.Rate,
+		Burst:       c.Burst,
+	}
+	if s.Weight == 0 {
+		s.Weight = 1
+	}
+	if s.Rate > 0 && s.Burst == 0 {
+		s.Burst = int(math.Ceil(s.Rate))
+	}
+	return s, nil
+}
+
+// Get returns the configured source, or one with the unknown sources settings.
+func (t *Table) Get(id uint) Source {
+	if t == nil {
+		return Source{ID: id, Weight: 1}
+	}
+	if s, ok := t.known[id]; ok {
+		return s
+	}
+	s := t.unknown
+	s.ID = id
+	return s
+}
+
+// Allowed is false for unknown sources under the reject policy.
+func (t *Table) Allowed(id uint) bool {
+	if t == nil || !t.reject {
+		return true
+	}
+	_, ok := t.known[id]
+	return ok
+}
+
+// Less orders sources by priority class and then by number.
+func (t *Table) Less(a, b uint) bool {
+	pa, pb := t.Get(a).Priority, t.Get(b).Priority
+	if pa != pb {
+		return pa < pb
+	}
+	return a < b
+}
+
+// Cap returns how many tests source id may hold in a buffer of maxSize. It is
+// at least one, so that a tiny share still lets the source in.
+func (t *Table) Cap(id uint, maxSize int64) int64 {
+	share := t.Get(id).MaxShare
+	if share == 0 {
+		return maxSize
+	}
+	return max(1, int64(share*float64(maxSize)))
+}
@@ -0,0 +1,78 @@
+package sources
+
+import (
+	"Dispatcher/internal/config"
+	"testing"
+)
+
+func TestNewValidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    []config.Source
+		unknown config.UnknownSources
+	}{
+		{name: "duplicate", list: []config.Source{{ID: 1}, {ID: 1}}},
+		{name: "negative weight", list: []config.Source{{ID: 1, Weight: -1}}},
+		{name: "share above one", list: []config.Source{{ID: 1, MaxShare: 1.5}}},
+		{name: "negative unknown share", unknown: config.UnknownSources{MaxShare: -0.1}},
+		{name: "unknown policy", unknown: config.UnknownSources{Policy: "drop"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.list, tt.unknown); err == nil {
+				t.Errorf("New accepted %+v, %+v", tt.list, tt.unknown)
+			}
+		})
+	}
+}
+
+func TestTable(t *testing.T) {
+	table, err := New(
+		[]config.Source{
+			{ID: 1, Priority: 2, Weight: 3},
+			{ID: 2, Priority: 0, MaxShare: 0.25},
+		},
+		config.UnknownSources{Policy: UnknownReject, Priority: 1},
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if s := table.Get(2); s.Weight != 1 {
+		t.Errorf("Get(2).Weight = %d, want default 1", s.Weight)
+	}
+	if s := table.Get(7); s.ID != 7 || s.Priority != 1 {
+		t.Errorf("Get(7) = %+v, want unknown source settings", s)
+	}
+
+	if !table.Allowed(1) || table.Allowed(7) {
+		t.Errorf("Allowed(1), Allowed(7) = %v, %v, want true, false", table.Allowed(1), table.Allowed(7))
+	}
+
+	// 2 is in class 0, unknown sources in class 1, 1 in class 2
+	if !table.Less(2, 7) || !table.Less(7, 1) || table.Less(1, 2) {
+		t.Errorf("Less does not order by priority class")
+	}
+	if !table.Less(7, 8) {
+		t.Errorf("Less(7, 8) = false, want source number to break ties")
+	}
+
+	if got := table.Cap(1, 10); got != 10 {
+		t.Errorf("Cap(1, 10) = %d, want the whole buffer", got)
+	}
+	if got := table.Cap(2, 10); got != 2 {
+		t.Errorf("Cap(2, 10) = %d, want 2", got)
+	}
+	if got := table.Cap(2, 2); got != 1 {
+		t.Errorf("Cap(2, 2) = %d, want at least 1", got)
+	}
+}
+
+func TestNilTable(t *testing.T) {
+	var table *Table
+
+	if !table.Allowed(5) || !table.Less(1, 2) || table.Cap(5, 8) != 8 {
+		t.Errorf("nil table does not treat sources alike")
+	}
+}
@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Producer is the part of the Kafka producer the publisher uses.
+type Producer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+// Publisher sends the collector's report to a Kafka topic every interval.
+type Publisher struct {
+	log       *slog.Logger
+	collector *Collector
+	producer  Producer
+	topic     string
+	interval  time.Duration
+
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func NewPublisher(log *slog.Logger, collector *Collector, producer Producer, topic string, interval time.Duration) *Publisher {
+	return &Publisher{
+		log:       log.With(slog.String("component", "stats.Publisher")),
+		collector: collector,
+		producer:  producer,
+		topic:     topic,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start runs the publishing loop in its own goroutine. A zero interval
+// disables publishing.
+func (p *Publisher) Start() {
+	p.startOnce.Do(func() {
+		if p.interval <= 0 {
+			close(p.done)
+			return
+		}
+		go p.run()
+	})
+}
+
+// Stop publishes the final report and waits for the loop to exit or ctx to be done.
+func (p *Publisher) Stop(ctx context.Context) error {
+	p.startOnce.Do(func() {
+		close(p.done)
+	})
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			p.publish()
+			return
+		case <-ticker.C:
+			p.publish()
+		}
+	}
+}
+
+func (p *Publisher) publish() {
+	data, err := json.Marshal(p.collector.Report())
+	if err != nil {
+		p.log.Error("failed to marshal stats", slog.Any("error", err))
+		return
+	}
+
+	err = p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
+		Value:          data,
+	}, nil)
+	if err != nil {
+		p.log.Error("failed to send stats to kafka", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,232 @@
+// Package stats computes the queueing-system characteristics of the run from
+// the lifecycle transitions of the buffer and the device registry.
+package stats
+
+import (
+	"Dispatcher/internal/dispatcher"
+	"Dispatcher/internal/http-server/handlers/test"
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Summary is the sample mean and variance of a duration in milliseconds.
+type Summary struct {
+	Count    int64   `json:"count"`
+	Mean     float64 `json:"mean_ms"`
+	Variance float64 `json:"variance_ms2"`
+}
+
+type SourceStats struct {
+	SourceID  uint  `json:"source_id"`
+	Generated int64 `json:"generated"`
+	// Refused counts tests rejected on arrival and tests evicted from the buffer.
+	Refused            int64   `json:"refused"`
+	DeadLettered       int64   `json:"dead_lettered"`
+	Completed          int64   `json:"completed"`
+	RefusalProbability float64 `json:"refusal_probability"`
+	// TimeInSystem is BufferWait plus ServiceTime of completed tests. Tests
+	// only complete when their results are posted, without them it and
+	// ServiceTime stay empty.
+	TimeInSystem Summary `json:"time_in_system"`
+	BufferWait   Summary `json:"buffer_wait"`
+	ServiceTime  Summary `json:"service_time"`
+}
+
+type DeviceStats struct {
+	ID          int32   `json:"id"`
+	Busy        bool    `json:"busy"`
+	Served      int64   `json:"served"`
+	BusyMs      float64 `json:"busy_ms"`
+	Utilisation float64 `json:"utilisation"`
+}
+
+type Report struct {
+	Since   time.Time     `json:"since"`
+	At      time.Time     `json:"at"`
+	Sources []SourceStats `json:"sources"`
+	Devices []DeviceStats `json:"devices"`
+}
+
+// DeviceStates is the device registry of the dispatcher.
+type DeviceStates interface {
+	Snapshot() []dispatcher.DeviceState
+}
+
+// moments accumulates mean and variance with Welford's method.
+type moments struct {
+	n    int64
+	mean float64
+	m2   float64
+}
+
+func (m *moments) add(d time.Duration) {
+	x := float64(d) / float64(time.Millisecond)
+	m.n++
+	delta := x - m.mean
+	m.mean += delta / float64(m.n)
+	m.m2 += delta * (x - m.mean)
+}
+
+func (m moments) summary() Summary {
+	s := Summary{Count: m.n, Mean: m.mean}
+	if m.n > 1 {
+		s.Variance = m.m2 / float64(m.n-1)
+	}
+	return s
+}
+
+type source struct {
+	generated, refused, dead, completed int64
+	system, wait, service               moments
+}
+
+// maxPending bounds the requests followed at once. When it is reached the
+// request received first is no longer followed, whatever its state.
+const maxPending = 100000
+
+type requestKey struct {
+	source, number uint
+}
+
+type request struct {
+	key        requestKey
+	received   time.Time
+	dispatched time.Time
+}
+
+// Collector observes the buffer and keeps running statistics per source.
+// Requests are followed from received to completed, the ones that never get
+// a result only count towards Generated and BufferWait. Completions come from
+// the results posted to the dispatcher, TimeInSystem and ServiceTime need
+// them.
+type Collector struct {
+	mu      sync.Mutex
+	devices DeviceStates
+	since   time.Time
+	sources map[uint]*source
+	// pending holds an element of order per followed request, order has
+	// them in the order they were received.
+	pending    map[requestKey]*list.Element
+	order      *list.List
+	maxPending int
+	now        func() time.Time
+}
+
+func NewCollector(devices DeviceStates) *Collector {
+	return &Collector{
+		devices:    devices,
+		since:      time.Now(),
+		sources:    make(map[uint]*source),
+		pending:    make(map[requestKey]*list.Element),
+		order:      list.New(),
+		maxPending: maxPending,
+		now:        time.Now,
+	}
+}
+
+// Observe implements test.TransitionObserver.
+func (c *Collector) Observe(sourceID, testNumber uint, tr test.Transition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, ok := c.sources[sourceID]
+	if !ok {
+		src = &source{}
+		c.sources[sourceID] = src
+	}
+
+	key := requestKey{sourceID, testNumber}
+	var req *request
+	if e, ok := c.pending[key]; ok {
+		req = e.Value.(*request)
+	}
+
+	switch tr.State {
+	case test.StateReceived:
+		src.generated++
+		c.forget(key)
+		if len(c.pending) >= c.maxPending {
+			c.forget(c.order.Front().Value.(*request).key)
+		}
+		c.pending[key] = c.order.PushBack(&request{key: key, received: tr.At})
+	case test.StateRejected, test.StateEvicted:
+		src.refused++
+		c.forget(key)
+	case test.StateDeadLettered:
+		src.dead++
+		c.forget(key)
+	case test.StateDispatched:
+		if req != nil {
+			req.dispatched = tr.At
+			src.wait.add(tr.At.Sub(req.received))
+		}
+	case test.StateCompleted:
+		if req != nil && !req.dispatched.IsZero() {
+			src.completed++
+			src.service.add(tr.At.Sub(req.dispatched))
+			src.system.add(tr.At.Sub(req.received))
+		}
+		c.forget(key)
+	}
+}
+
+// forget stops following the request, c.mu must be held.
+func (c *Collector) forget(key requestKey) {
+	if e, ok := c.pending[key]; ok {
+		c.order.Remove(e)
+		delete(c.pending, key)
+	}
+}
+
+// Report returns the statistics collected so far.
+func (c *Collector) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	report := Report{
+		Since:   c.since,
+		At:      now,
+		Sources: make([]SourceStats, 0, len(c.sources)),
+	}
+
+	for id, src := range c.sources {
+		s := SourceStats{
+			SourceID:     id,
+			Generated:    src.generated,
+			Refused:      src.refused,
+			DeadLettered: src.dead,
+			Completed:    src.completed,
+			TimeInSystem: src.system.summary(),
+			BufferWait:   src.wait.summary(),
+			ServiceTime:  src.service.summary(),
+		}
+		if src.generated > 0 {
+			s.RefusalProbability = float64(src.refused) / float64(src.generated)
+		}
+		report.Sources = append(report.Sources, s)
+	}
+	sort.Slice(report.Sources, func(i, j int) bool {
+		return report.Sources[i].SourceID < report.Sources[j].SourceID
+	})
+
+	elapsed := now.Sub(c.since)
+	states := c.devices.Snapshot()
+	report.Devices = make([]DeviceStats, 0, len(states))
+	for _, st := range states {
+		d := DeviceStats{
+			ID:     st.ID,
+			Busy:   st.Busy,
+			Served: st.Served,
+			BusyMs: float64(st.BusyTime) / float64(time.Millisecond),
+		}
+		if elapsed > 0 {
+			d.Utilisation = float64(st.BusyTime) / float64(elapsed)
+		}
+		report.Devices = append(report.Devices, d)
+	}
+
+	return report
+}
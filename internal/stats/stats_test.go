@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"Dispatcher/internal/dispatcher"
+	"Dispatcher/internal/http-server/handlers/test"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type fakeDevices []dispatcher.DeviceState
+
+func (d fakeDevices) Snapshot() []dispatcher.DeviceState {
+	return d
+}
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(ms int) time.Time {
+	return start.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func observe(c *Collector, source, number uint, state string, ms int) {
+	c.Observe(source, number, test.Transition{State: state, At: at(ms)})
+}
+
+func TestCollectorSources(t *testing.T) {
+	c := NewCollector(fakeDevices{})
+
+	// 1/1 goes straight to a device, 1/2 waits 100ms in the buffer
+	observe(c, 1, 1, test.StateReceived, 0)
+	observe(c, 1, 1, test.StateDispatched, 0)
+	observe(c, 1, 2, test.StateReceived, 10)
+	observe(c, 1, 2, test.StateBuffered, 10)
+	observe(c, 1, 1, test.StateCompleted, 200)
+	observe(c, 1, 2, test.StateDispatched, 110)
+	observe(c, 1, 2, test.StateCompleted, 410)
+
+	// 2/1 is evicted, 2/2 is rejected, 2/3 is never dispatched
+	observe(c, 2, 1, test.StateReceived, 0)
+	observe(c, 2, 1, test.StateBuffered, 0)
+	observe(c, 2, 1, test.StateEvicted, 50)
+	observe(c, 2, 2, test.StateReceived, 60)
+	observe(c, 2, 2, test.StateRejected, 60)
+	observe(c, 2, 3, test.StateReceived, 70)
+
+	report := c.Report()
+	if len(report.Sources) != 2 {
+		t.Fatalf("report has %d sources, want 2", len(report.Sources))
+	}
+
+	s1 := report.Sources[0]
+	if s1.SourceID != 1 || s1.Generated != 2 || s1.Completed != 2 || s1.Refused != 0 || s1.RefusalProbability != 0 {
+		t.Errorf("source 1 = %+v", s1)
+	}
+	checkSummary(t, "source 1 buffer wait", s1.BufferWait, 2, 50, 5000)
+	checkSummary(t, "source 1 service time", s1.ServiceTime, 2, 250, 5000)
+	checkSummary(t, "source 1 time in system", s1.TimeInSystem, 2, 300, 20000)
+
+	s2 := report.Sources[1]
+	if s2.Generated != 3 || s2.Refused != 2 || s2.Completed != 0 {
+		t.Errorf("source 2 = %+v", s2)
+	}
+	if math.Abs(s2.RefusalProbability-2.0/3) > 1e-9 {
+		t.Errorf("source 2 refusal probability = %v, want 2/3", s2.RefusalProbability)
+	}
+}
+
+func TestCollectorForgetsOldestPending(t *testing.T) {
+	c := NewCollector(fakeDevices{})
+	c.maxPending = 2
+
+	// 1/1 still waits in the buffer when 1/3 arrives, 1/2 is on a device
+	observe(c, 1, 1, test.StateReceived, 0)
+	observe(c, 1, 2, test.StateReceived, 10)
+	observe(c, 1, 2, test.StateDispatched, 10)
+	observe(c, 1, 3, test.StateReceived, 20)
+	if len(c.pending) != 2 || c.order.Len() != 2 {
+		t.Fatalf("following %d requests, want 2", len(c.pending))
+	}
+
+	observe(c, 1, 1, test.StateDispatched, 30)
+	observe(c, 1, 2, test.StateCompleted, 110)
+	observe(c, 1, 3, test.StateDispatched, 20)
+
+	s := c.Report().Sources[0]
+	if s.Generated != 3 || s.Completed != 1 {
+		t.Errorf("source 1 = %+v, want 3 generated and 1 completed", s)
+	}
+	checkSummary(t, "buffer wait", s.BufferWait, 2, 0, 0)
+}
+
+func checkSummary(t *testing.T, name string, got Summary, count int64, mean, variance float64) {
+	t.Helper()
+
+	if got.Count != count || math.Abs(got.Mean-mean) > 1e-9 || math.Abs(got.Variance-variance) > 1e-9 {
+		t.Errorf("%s = %+v, want count %d, mean %v, variance %v", name, got, count, mean, variance)
+	}
+}
+
+func TestCollectorDevices(t *testing.T) {
+	c := NewCollector(fakeDevices{
+		{ID: 1, Served: 3, BusyTime: 250 * time.Millisecond},
+		{ID: 2, Busy: true, BusyTime: time.Second},
+	})
+	c.since = start
+	c.now = func() time.Time { return at(1000) }
+
+	report := c.Report()
+	if len(report.Devices) != 2 {
+		t.Fatalf("report has %d devices, want 2", len(report.Devices))
+	}
+	if d := report.Devices[0]; d.Served != 3 || d.BusyMs != 250 || d.Utilisation != 0.25 {
+		t.Errorf("device 1 = %+v, want 3 served, utilisation 0.25", d)
+	}
+	if d := report.Devices[1]; !d.Busy || d.Utilisation != 1 {
+		t.Errorf("device 2 = %+v, want busy, utilisation 1", d)
+	}
+}
+
+type fakeProducer struct {
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+func (p *fakeProducer) Produce(msg *kafka.Message, _ chan kafka.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.messages = append(p.messages, msg.Value)
+	return nil
+}
+
+func TestPublisherSendsFinalReport(t *testing.T) {
+	c := NewCollector(fakeDevices{})
+	observe(c, 1, 1, test.StateReceived, 0)
+
+	producer := &fakeProducer{}
+	p := NewPublisher(slog.New(slog.NewTextHandler(io.Discard, nil)), c, producer, "stats", time.Hour)
+	p.Start()
+
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if len(producer.messages) != 1 {
+		t.Fatalf("published %d reports, want 1", len(producer.messages))
+	}
+
+	var report Report
+	if err := json.Unmarshal(producer.messages[0], &report); err != nil {
+		t.Fatalf("unmarshal report: %v", err)
+	}
+	if len(report.Sources) != 1 || report.Sources[0].Generated != 1 {
+		t.Errorf("published report = %+v", report)
+	}
+}
@@ -0,0 +1,169 @@
+package eviction
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	SourceOrder    = "source_order"
+	Oldest         = "oldest"
+	Newest         = "newest"
+	LowestPriority = "lowest_priority"
+	Random         = "random"
+	Reject         = "reject"
+)
+
+// Details of the rejected transition of a refused incoming test.
+const (
+	DetailFull  = "buffer is full"
+	DetailShare = "source holds its share of the buffer"
+)
+
+// Detail returns why an incoming test was refused. atShare means its source
+// already held its maximum share of the buffer.
+func Detail(atShare bool) string {
+	if atShare {
+		return DetailShare
+	}
+	return DetailFull
+}
+
+// Policy decides what happens to the circular buffer when a new test arrives
+// and every position is taken.
+type Policy interface {
+	// Victim returns the buffered test that has to be moved to the trash table.
+	// ok is false when the incoming test must be refused instead.
+	Victim(buffered []test.BufferedTest) (victim test.BufferedTest, ok bool)
+}
+
+// New returns the policy registered under name. Empty name means SourceOrder.
+// Source priorities come from table.
+func New(name string, table *sources.Table) (Policy, error) {
+	switch name {
+	case "", SourceOrder:
+		return sourceOrder{table}, nil
+	case Oldest:
+		return oldest{}, nil
+	case Newest:
+		return newest{}, nil
+	case LowestPriority:
+		return lowestPriority{table}, nil
+	case Random:
+		return NewRandom(rand.New(rand.NewSource(time.Now().UnixNano()))), nil
+	case Reject:
+		return reject{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown eviction policy: %q", name)
+}
+
+// sourceOrder evicts from the lowest priority class first. Within a class
+// the test that sorts first by source number and then by request number goes,
+// which is how the buffer always behaved.
+type sourceOrder struct {
+	table *sources.Table
+}
+
+func (p sourceOrder) Victim(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	return pick(buffered, func(a, b test.BufferedTest) bool {
+		if pa, pb := p.table.Get(a.SourceID).Priority, p.table.Get(b.SourceID).Priority; pa != pb {
+			return pa > pb
+		}
+		if a.SourceID != b.SourceID {
+			return a.SourceID < b.SourceID
+		}
+		return a.TestNumber < b.TestNumber
+	})
+}
+
+// oldest evicts the test that has been waiting in the buffer the longest.
+type oldest struct{}
+
+func (oldest) Victim(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	return pick(buffered, func(a, b test.BufferedTest) bool {
+		if !a.ArrivalTime.Equal(b.ArrivalTime) {
+			return a.ArrivalTime.Before(b.ArrivalTime)
+		}
+		return a.Pos < b.Pos
+	})
+}
+
+// newest evicts the test that was buffered last.
+type newest struct{}
+
+func (newest) Victim(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	return pick(buffered, func(a, b test.BufferedTest) bool {
+		if !a.ArrivalTime.Equal(b.ArrivalTime) {
+			return a.ArrivalTime.After(b.ArrivalTime)
+		}
+		return a.Pos > b.Pos
+	})
+}
+
+// lowestPriority evicts a test of the lowest priority source, the one that
+// is dispatched last. Within the source the newest test goes.
+type lowestPriority struct {
+	table *sources.Table
+}
+
+func (p lowestPriority) Victim(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	return pick(buffered, func(a, b test.BufferedTest) bool {
+		if a.SourceID != b.SourceID {
+			return p.table.Less(b.SourceID, a.SourceID)
+		}
+		if !a.ArrivalTime.Equal(b.ArrivalTime) {
+			return a.ArrivalTime.After(b.ArrivalTime)
+		}
+		return a.Pos > b.Pos
+	})
+}
+
+type random struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandom returns a policy that evicts a uniformly chosen test using rnd.
+func NewRandom(rnd *rand.Rand) Policy {
+	return &random{rnd: rnd}
+}
+
+func (r *random) Victim(buffered []test.BufferedTest) (test.BufferedTest, bool) {
+	if len(buffered) == 0 {
+		return test.BufferedTest{}, false
+	}
+
+	r.mu.Lock()
+	i := r.rnd.Intn(len(buffered))
+	r.mu.Unlock()
+
+	return buffered[i], true
+}
+
+// reject keeps the buffer as it is and refuses the incoming test.
+type reject struct{}
+
+func (reject) Victim([]test.BufferedTest) (test.BufferedTest, bool) {
+	return test.BufferedTest{}, false
+}
+
+// pick returns the element for which less reports true against every other one.
+func pick(buffered []test.BufferedTest, less func(a, b test.BufferedTest) bool) (test.BufferedTest, bool) {
+	if len(buffered) == 0 {
+		return test.BufferedTest{}, false
+	}
+
+	victim := buffered[0]
+	for _, t := range buffered[1:] {
+		if less(t, victim) {
+			victim = t
+		}
+	}
+
+	return victim, true
+}
@@ -0,0 +1,132 @@
+package eviction
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"Dispatcher/internal/storage/storagetest"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantPos int64
+	}{
+		{name: "source order", policy: SourceOrder, wantPos: 3},
+		{name: "default", policy: "", wantPos: 3},
+		{name: "oldest", policy: Oldest, wantPos: 2},
+		{name: "newest", policy: Newest, wantPos: 3},
+		{name: "lowest priority", policy: LowestPriority, wantPos: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.policy, nil)
+			if err != nil {
+				t.Fatalf("New(%q): %v", tt.policy, err)
+			}
+
+			victim, ok := p.Victim(storagetest.Buffered())
+			if !ok {
+				t.Fatalf("expected a victim")
+			}
+			if victim.Pos != tt.wantPos {
+				t.Errorf("victim pos = %d, want %d", victim.Pos, tt.wantPos)
+			}
+		})
+	}
+}
+
+func TestLowestPriorityUsesSourceClasses(t *testing.T) {
+	table, err := sources.New([]config.Source{{ID: 3, Priority: 0}, {ID: 1, Priority: 2}}, config.UnknownSources{Priority: 1})
+	if err != nil {
+		t.Fatalf("sources.New: %v", err)
+	}
+	p, _ := New(LowestPriority, table)
+
+	// source 1 is in the lowest class, its newest test goes
+	if victim, _ := p.Victim(storagetest.Buffered()); victim.Pos != 3 {
+		t.Errorf("victim pos = %d, want 3", victim.Pos)
+	}
+}
+
+func TestSourceOrderUsesSourceClasses(t *testing.T) {
+	table, err := sources.New([]config.Source{{ID: 1, Priority: 1}, {ID: 2, Priority: 0}}, config.UnknownSources{})
+	if err != nil {
+		t.Fatalf("sources.New: %v", err)
+	}
+	p, _ := New(SourceOrder, table)
+
+	buffered := []test.BufferedTest{
+		{Pos: 1, TestRequest: test.TestRequest{SourceID: 2, TestNumber: 1}},
+		{Pos: 2, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 2}},
+		{Pos: 3, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}},
+	}
+
+	// source 1 has the smaller number but the lower priority, its first test goes
+	if victim, _ := p.Victim(buffered); victim.Pos != 3 {
+		t.Errorf("victim pos = %d, want 3", victim.Pos)
+	}
+}
+
+func TestTieBreaks(t *testing.T) {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	same := []test.BufferedTest{
+		{Pos: 5, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}, ArrivalTime: at},
+		{Pos: 2, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 2}, ArrivalTime: at},
+		{Pos: 7, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 3}, ArrivalTime: at},
+	}
+
+	if v, _ := (oldest{}).Victim(same); v.Pos != 2 {
+		t.Errorf("oldest tie break pos = %d, want 2", v.Pos)
+	}
+	if v, _ := (newest{}).Victim(same); v.Pos != 7 {
+		t.Errorf("newest tie break pos = %d, want 7", v.Pos)
+	}
+	if v, _ := (lowestPriority{}).Victim(same); v.Pos != 7 {
+		t.Errorf("lowest priority tie break pos = %d, want 7", v.Pos)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	p := NewRandom(rand.New(rand.NewSource(1)))
+	in := storagetest.Buffered()
+
+	seen := make(map[int64]bool)
+	for i := 0; i < 200; i++ {
+		victim, ok := p.Victim(in)
+		if !ok {
+			t.Fatalf("expected a victim")
+		}
+		seen[victim.Pos] = true
+	}
+
+	if len(seen) != len(in) {
+		t.Errorf("random policy picked %d distinct positions, want %d", len(seen), len(in))
+	}
+
+	if _, ok := p.Victim(nil); ok {
+		t.Errorf("random policy picked a victim from an empty buffer")
+	}
+}
+
+func TestReject(t *testing.T) {
+	p, err := New(Reject, nil)
+	if err != nil {
+		t.Fatalf("New(%q): %v", Reject, err)
+	}
+
+	if _, ok := p.Victim(storagetest.Buffered()); ok {
+		t.Errorf("reject policy evicted a buffered test")
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	if _, err := New("lottery", nil); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
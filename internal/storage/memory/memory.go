@@ -0,0 +1,467 @@
+package memory
+
+import (
+	"Dispatcher/internal/buffers"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"Dispatcher/internal/storage/eviction"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	test.BufferedTest
+	retryAt time.Time
+}
+
+type deadRow struct {
+	test.BufferedTest
+	lastError string
+	deadTime  time.Time
+}
+
+// Storage is an in-memory circular buffer with the same semantics as the
+// Postgres one: named buffers laid out in one position space, a cursor with
+// wraparound per buffer and a trash of refused tests.
+type Storage struct {
+	mu     sync.Mutex
+	log    *slog.Logger
+	layout *buffers.Layout
+	// cursors and evictions are per buffer of layout, last is the buffer
+	// written last.
+	cursors   []int64
+	evictions []eviction.Policy
+	last      int
+	sources   *sources.Table
+	buffer    map[int64]entry
+	trash     []test.TrashTest
+	trashID   int64
+	results   []test.Result
+	resultID  int64
+	dead      []deadRow
+	history   map[requestKey][]test.Transition
+
+	observers []test.TransitionObserver
+}
+
+type requestKey struct {
+	source, number uint
+}
+
+// New returns an empty storage with the buffers of layout, table holds the
+// source limits and priorities the buffers enforce.
+func New(log *slog.Logger, table *sources.Table, layout *buffers.Layout) (*Storage, error) {
+	const op = "storage.memory.New"
+
+	st := &Storage{
+		log:     log,
+		layout:  layout,
+		sources: table,
+		buffer:  make(map[int64]entry, layout.Size()),
+		history: make(map[requestKey][]test.Transition),
+	}
+	for _, b := range layout.Buffers() {
+		policy, err := eviction.New(b.EvictionPolicy, table)
+		if err != nil {
+			return nil, fmt.Errorf("%s: buffer %q: %w", op, b.Name, err)
+		}
+		st.evictions = append(st.evictions, policy)
+		st.cursors = append(st.cursors, b.Offset+1)
+	}
+
+	log.With(slog.String("op", op)).Info("using in-memory storage")
+
+	return st, nil
+}
+
+func (st *Storage) Close() error {
+	return nil
+}
+
+func (st *Storage) CheckAvailableSpace() (int64, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// tests left outside the layout by a smaller configuration take no space
+	var held int64
+	for pos := range st.buffer {
+		if _, ok := st.layout.Of(pos); ok {
+			held++
+		}
+	}
+	return st.layout.Size() - held, nil
+}
+
+func (st *Storage) GetMaxSize() int64 {
+	return st.layout.Size()
+}
+
+func (st *Storage) GetCurrId() int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.cursors[st.last]
+}
+
+func (st *Storage) SaveTest(t *test.TestRequest) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.saveTest(t)
+	return nil
+}
+
+func (st *Storage) SaveTests(reqs []test.TestRequest) ([]test.SaveResult, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	results := make([]test.SaveResult, 0, len(reqs))
+	for i := range reqs {
+		results = append(results, st.saveTest(&reqs[i]))
+	}
+	return results, nil
+}
+
+// saveTest must be called with st.mu held. The test is recorded as received
+// and goes to the buffer its source is routed to.
+func (st *Storage) saveTest(t *test.TestRequest) test.SaveResult {
+	const op = "storage.memory.SaveTest"
+	log := st.log.With(slog.String("op", op))
+
+	st.record(t.SourceID, t.TestNumber, test.Transition{State: test.StateReceived})
+
+	idx := st.layout.Route(t.SourceID)
+	b := st.layout.At(idx)
+
+	var held int64
+	for pos, e := range st.buffer {
+		if b.Contains(pos) && e.SourceID == t.SourceID {
+			held++
+		}
+	}
+	atShare := held >= st.sources.Cap(t.SourceID, b.Size)
+
+	var result test.SaveResult
+	pos, free := b.Free(st.cursors[idx], func(pos int64) bool {
+		_, taken := st.buffer[pos]
+		return taken
+	})
+	if !free || atShare {
+		victim, ok := st.moveToTrash(t, idx, atShare)
+		if !ok {
+			log.Info("Test refused by eviction policy",
+				"source_number", t.SourceID,
+				"test_number", t.TestNumber,
+				"buffer", b.Name,
+			)
+			return test.SaveResult{Rejected: true}
+		}
+		result.Evicted = &victim.TestRequest
+		pos = victim.Pos
+	}
+
+	log.Info("Saving test",
+		"source_number", t.SourceID,
+		"test_number", t.TestNumber,
+		"buffer", b.Name,
+	)
+
+	now := time.Now()
+	st.buffer[pos] = entry{
+		BufferedTest: test.BufferedTest{
+			TestRequest: *t,
+			Pos:         pos,
+			ArrivalTime: now,
+		},
+		retryAt: now,
+	}
+	st.cursors[idx] = pos
+	st.last = idx
+	st.record(t.SourceID, t.TestNumber, test.Transition{State: test.StateBuffered, Pos: &pos})
+
+	result.Pos = pos
+	return result
+}
+
+// moveToTrash must be called with st.mu held. It returns the test evicted
+// from buffer idx, or false when incoming itself was refused. With atShare
+// only tests of the incoming source may be evicted.
+func (st *Storage) moveToTrash(incoming *test.TestRequest, idx int, atShare bool) (test.BufferedTest, bool) {
+	now := time.Now()
+	b := st.layout.At(idx)
+
+	var evictable []test.BufferedTest
+	for _, t := range st.bufferedTests() {
+		if b.Contains(t.Pos) && !t.InFlight && (!atShare || t.SourceID == incoming.SourceID) {
+			evictable = append(evictable, t)
+		}
+	}
+
+	victim, ok := st.evictions[idx].Victim(evictable)
+	if !ok {
+		st.toTrash(test.TrashTest{
+			TestRequest: *incoming,
+			Reason:      test.StateRejected,
+			ArrivalTime: now,
+			RemovalTime: now,
+		})
+		st.record(incoming.SourceID, incoming.TestNumber, test.Transition{
+			State:  test.StateRejected,
+			Detail: eviction.Detail(atShare),
+		})
+		return test.BufferedTest{}, false
+	}
+
+	st.toTrash(test.TrashTest{
+		TestRequest: victim.TestRequest,
+		Reason:      test.StateEvicted,
+		ArrivalTime: victim.ArrivalTime,
+		RemovalTime: now,
+	})
+	delete(st.buffer, victim.Pos)
+	st.record(victim.SourceID, victim.TestNumber, test.Transition{
+		State:  test.StateEvicted,
+		Pos:    &victim.Pos,
+		Detail: fmt.Sprintf("replaced by %d/%d", incoming.SourceID, incoming.TestNumber),
+	})
+
+	return victim, true
+}
+
+// toTrash must be called with st.mu held.
+func (st *Storage) toTrash(t test.TrashTest) {
+	st.trashID++
+	t.ID = st.trashID
+	st.trash = append(st.trash, t)
+}
+
+func (st *Storage) PendingRefusals(limit int) ([]test.TrashTest, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	n := min(limit, len(st.trash))
+	return append([]test.TrashTest(nil), st.trash[:n]...), nil
+}
+
+// MarkRefusalsNotified forgets the notified trash tests.
+func (st *Storage) MarkRefusalsNotified(ids []int64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	notified := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		notified[id] = true
+	}
+
+	rest := st.trash[:0]
+	for _, t := range st.trash {
+		if !notified[t.ID] {
+			rest = append(rest, t)
+		}
+	}
+	st.trash = rest
+
+	return nil
+}
+
+func (st *Storage) RecordResult(r test.Result, tr test.Transition) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.record(r.SourceID, r.TestNumber, tr)
+	st.resultID++
+	r.ID = st.resultID
+	st.results = append(st.results, r)
+	return nil
+}
+
+func (st *Storage) PendingResults(limit int) ([]test.Result, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	n := min(limit, len(st.results))
+	return append([]test.Result(nil), st.results[:n]...), nil
+}
+
+// MarkResultsSent forgets the sent results.
+func (st *Storage) MarkResultsSent(ids []int64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sent := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		sent[id] = true
+	}
+
+	rest := st.results[:0]
+	for _, r := range st.results {
+		if !sent[r.ID] {
+			rest = append(rest, r)
+		}
+	}
+	st.results = rest
+
+	return nil
+}
+
+func (st *Storage) ListTests() ([]test.BufferedTest, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.bufferedTests(), nil
+}
+
+func (st *Storage) GetTest() (int64, int64, int64, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var buffered []test.BufferedTest
+	for _, t := range st.bufferedTests() {
+		if !t.InFlight {
+			buffered = append(buffered, t)
+		}
+	}
+	if len(buffered) == 0 {
+		return -1, -1, -1, nil
+	}
+
+	// by priority class, then by source and request number
+	sort.Slice(buffered, func(i, j int) bool {
+		if buffered[i].SourceID != buffered[j].SourceID {
+			return st.sources.Less(buffered[i].SourceID, buffered[j].SourceID)
+		}
+		return buffered[i].TestNumber < buffered[j].TestNumber
+	})
+
+	next := buffered[0]
+	return next.Pos, int64(next.SourceID), int64(next.TestNumber), nil
+}
+
+func (st *Storage) DeleteTest(pos int64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.buffer, pos)
+	return nil
+}
+
+func (st *Storage) MarkInFlight(pos int64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, ok := st.buffer[pos]
+	if !ok || e.InFlight {
+		return fmt.Errorf("storage.memory.MarkInFlight: pos %d: %w", pos, test.ErrTestNotFound)
+	}
+
+	e.InFlight = true
+	st.buffer[pos] = e
+
+	return nil
+}
+
+func (st *Storage) RetryTest(pos int64, backoff time.Duration, maxAttempts int, reason string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, ok := st.buffer[pos]
+	if !ok || !e.InFlight {
+		return false, fmt.Errorf("storage.memory.RetryTest: pos %d: %w", pos, test.ErrTestNotFound)
+	}
+
+	now := time.Now()
+	e.InFlight = false
+	e.Attempts++
+	e.retryAt = now.Add(backoff)
+	st.record(e.SourceID, e.TestNumber, test.Transition{State: test.StateSendFailed, Pos: &pos, Detail: reason})
+
+	if e.Attempts >= maxAttempts {
+		st.dead = append(st.dead, deadRow{BufferedTest: e.BufferedTest, lastError: reason, deadTime: now})
+		delete(st.buffer, pos)
+		st.record(e.SourceID, e.TestNumber, test.Transition{
+			State:  test.StateDeadLettered,
+			Detail: fmt.Sprintf("%d attempts", e.Attempts),
+		})
+		return true, nil
+	}
+
+	st.buffer[pos] = e
+	return false, nil
+}
+
+func (st *Storage) DispatchTest(pos int64, deviceID int32) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, ok := st.buffer[pos]
+	if !ok || !e.InFlight {
+		return fmt.Errorf("storage.memory.DispatchTest: pos %d: %w", pos, test.ErrTestNotFound)
+	}
+
+	delete(st.buffer, pos)
+	st.record(e.SourceID, e.TestNumber, test.Transition{State: test.StateDispatched, Pos: &pos, DeviceID: &deviceID})
+
+	return nil
+}
+
+func (st *Storage) RecordTransition(sourceID, testNumber uint, tr test.Transition) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.record(sourceID, testNumber, tr)
+	return nil
+}
+
+func (st *Storage) RecordTransitions(records []test.Record) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, r := range records {
+		st.record(r.SourceID, r.TestNumber, r.Transition)
+	}
+	return nil
+}
+
+func (st *Storage) GetLifecycle(sourceID, testNumber uint) ([]test.Transition, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return append([]test.Transition(nil), st.history[requestKey{sourceID, testNumber}]...), nil
+}
+
+func (st *Storage) Subscribe(o test.TransitionObserver) {
+	st.observers = append(st.observers, o)
+}
+
+// record must be called with st.mu held. Like the Postgres storage it stamps
+// the transition with the current time.
+func (st *Storage) record(sourceID, testNumber uint, tr test.Transition) {
+	tr.At = time.Now()
+	key := requestKey{sourceID, testNumber}
+	st.history[key] = append(st.history[key], tr)
+
+	for _, o := range st.observers {
+		o.Observe(sourceID, testNumber, tr)
+	}
+}
+
+// bufferedTests must be called with st.mu held.
+func (st *Storage) bufferedTests() []test.BufferedTest {
+	now := time.Now()
+	buffered := make([]test.BufferedTest, 0, len(st.buffer))
+	for _, e := range st.buffer {
+		t := e.BufferedTest
+		t.Buffer = st.layout.Name(t.Pos)
+		t.Ready = !t.InFlight && !e.retryAt.After(now)
+		buffered = append(buffered, t)
+	}
+
+	sort.Slice(buffered, func(i, j int) bool {
+		return buffered[i].Pos < buffered[j].Pos
+	})
+
+	return buffered
+}
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/storage/storagetest"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func newTestStorage(t *testing.T, cfg *config.Config) test.TestCycleBuffer {
+	t.Helper()
+
+	table, layout := storagetest.Layout(t, cfg)
+	st, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), table, layout)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return st
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, newTestStorage)
+}
+
+func TestReopen(t *testing.T) {
+	storagetest.RunReopen(t, newTestStorage, func(t *testing.T, buf test.TestCycleBuffer, cfg *config.Config) test.TestCycleBuffer {
+		st := newTestStorage(t, cfg).(*Storage)
+		st.buffer = buf.(*Storage).buffer
+		return st
+	})
+}
@@ -0,0 +1,208 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the advisory lock key that serializes concurrent migrators.
+const lockID = 7_402_113
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// binary than the running one.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// ErrSchemaOutdated is returned by Check when there are pending migrations.
+var ErrSchemaOutdated = errors.New("database schema is outdated, run the migrate command")
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Load returns the embedded migrations ordered by version. File names must
+// look like 0001_name.sql and versions must go without gaps starting from 1.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("can't read migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		prefix, rest, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name: %s", e.Name())
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %s: %w", e.Name(), err)
+		}
+
+		body, err := files.ReadFile(path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("can't read migration %s: %w", e.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: rest, SQL: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d_%s is out of sequence, want version %d", m.Version, m.Name, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Latest returns the version the embedded migrations bring the schema to.
+func Latest() (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+// Current returns the version recorded in schema_version, 0 for a fresh
+// database that has no schema_version table yet. It only reads.
+func Current(ctx context.Context, db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT to_regclass('schema_version') IS NOT NULL;").Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("can't look up schema_version table: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version;").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("can't read schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// Check makes sure the schema is exactly at Latest.
+func Check(ctx context.Context, db *sql.DB) error {
+	current, err := Current(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	latest, err := Latest()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case current > latest:
+		return fmt.Errorf("%w: database at %d, binary at %d", ErrSchemaTooNew, current, latest)
+	case current < latest:
+		return fmt.Errorf("%w: database at %d, binary at %d", ErrSchemaOutdated, current, latest)
+	}
+
+	return nil
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns how many were applied. It refuses to touch a newer schema.
+func Up(ctx context.Context, db *sql.DB, log *slog.Logger) (int, error) {
+	const op = "storage.postgres.migrations.Up"
+	log = log.With(slog.String("op", op))
+
+	migrations, err := Load()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := ensureVersionTable(ctx, db); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		ok, err := apply(ctx, db, m, len(migrations))
+		if err != nil {
+			return applied, fmt.Errorf("%s: %w", op, err)
+		}
+		if ok {
+			log.Info("migration applied", slog.Int("version", m.Version), slog.String("name", m.Name))
+			applied++
+		}
+	}
+
+	return applied, nil
+}
+
+func apply(ctx context.Context, db *sql.DB, m Migration, latest int) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction error: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1);", lockID); err != nil {
+		return false, fmt.Errorf("can't take migration lock: %w", err)
+	}
+
+	var current int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version;").Scan(&current)
+	if err != nil {
+		return false, fmt.Errorf("can't read schema version: %w", err)
+	}
+	if current > latest {
+		return false, fmt.Errorf("%w: database at %d, binary at %d", ErrSchemaTooNew, current, latest)
+	}
+	if current >= m.Version {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_version (version, name) VALUES ($1, $2);", m.Version, m.Name)
+	if err != nil {
+		return false, fmt.Errorf("can't record migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit error: %v", err)
+	}
+
+	return true, nil
+}
+
+func ensureVersionTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_version ("+
+		"version integer PRIMARY KEY,"+
+		"name text NOT NULL,"+
+		"applied_at timestamp NOT NULL DEFAULT now());")
+	if err != nil {
+		return fmt.Errorf("can't create schema_version table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatalf("no embedded migrations")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d", i, m.Version)
+		}
+		if m.Name == "" || m.SQL == "" {
+			t.Errorf("migration %d is empty: %+v", m.Version, m)
+		}
+	}
+
+	latest, err := Latest()
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if latest != len(migrations) {
+		t.Errorf("Latest = %d, want %d", latest, len(migrations))
+	}
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS circular_buffer (
+    pos integer PRIMARY KEY,
+    source_number integer NOT NULL,
+    request_number integer NOT NULL,
+    arrival_time timestamp NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS trash_table (
+    source_number integer NOT NULL,
+    request_number integer NOT NULL,
+    arrival_time timestamp NOT NULL,
+    removal_time timestamp NOT NULL,
+    taken boolean NOT NULL DEFAULT false
+);
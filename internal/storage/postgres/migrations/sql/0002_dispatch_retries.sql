@@ -0,0 +1,13 @@
+ALTER TABLE circular_buffer
+    ADD COLUMN IF NOT EXISTS in_flight boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_attempt_at timestamp NOT NULL DEFAULT now();
+
+CREATE TABLE IF NOT EXISTS dead_letter (
+    source_number integer NOT NULL,
+    request_number integer NOT NULL,
+    arrival_time timestamp NOT NULL,
+    attempts integer NOT NULL,
+    last_error text NOT NULL,
+    dead_time timestamp NOT NULL DEFAULT now()
+);
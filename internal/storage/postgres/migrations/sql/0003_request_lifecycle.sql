@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS request_lifecycle (
+    id bigserial PRIMARY KEY,
+    source_number integer NOT NULL,
+    request_number integer NOT NULL,
+    state text NOT NULL,
+    pos integer,
+    device_id integer,
+    detail text NOT NULL DEFAULT '',
+    at timestamp NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS request_lifecycle_request_idx
+    ON request_lifecycle (source_number, request_number, id);
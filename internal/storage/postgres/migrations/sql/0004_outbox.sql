@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial PRIMARY KEY,
+    key text NOT NULL,
+    payload bytea NOT NULL,
+    created_at timestamp NOT NULL DEFAULT now(),
+    sent_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unsent_idx
+    ON outbox (id) WHERE sent_at IS NULL;
@@ -0,0 +1,13 @@
+-- trash_table becomes the queue of refusals to notify sources about. Rows
+-- from before it existed count as notified.
+ALTER TABLE trash_table
+    ADD COLUMN IF NOT EXISTS id bigserial PRIMARY KEY,
+    ADD COLUMN IF NOT EXISTS reason text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS notified_at timestamp;
+
+UPDATE trash_table SET notified_at = removal_time;
+
+ALTER TABLE trash_table DROP COLUMN IF EXISTS taken;
+
+CREATE INDEX IF NOT EXISTS trash_table_pending_idx
+    ON trash_table (id) WHERE notified_at IS NULL;
@@ -0,0 +1,15 @@
+-- Results devices reported, queued until UserService got them.
+CREATE TABLE IF NOT EXISTS result_queue (
+    id bigserial PRIMARY KEY,
+    source_number integer NOT NULL,
+    request_number integer NOT NULL,
+    device_id integer NOT NULL,
+    passed boolean NOT NULL,
+    duration_ms bigint NOT NULL,
+    msg text NOT NULL DEFAULT '',
+    reported_at timestamp NOT NULL DEFAULT now(),
+    sent_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS result_queue_pending_idx
+    ON result_queue (id) WHERE sent_at IS NULL;
@@ -1,305 +1,944 @@
 package storage
 
 import (
+	"Dispatcher/internal/buffers"
 	"Dispatcher/internal/config"
+	"Dispatcher/internal/events"
 	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/outbox"
+	"Dispatcher/internal/sources"
+	"Dispatcher/internal/storage/eviction"
+	"Dispatcher/internal/storage/postgres/migrations"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Storage struct {
-	db      *sql.DB
-	log     *slog.Logger
-	maxSize int64
-	currId  int64
+	mu     sync.Mutex
+	db     *sql.DB
+	log    *slog.Logger
+	layout *buffers.Layout
+	// cursors and evictions are per buffer of layout, last is the buffer
+	// written last.
+	cursors   []int64
+	evictions []eviction.Policy
+	last      int
+	sources   *sources.Table
+	// outbox makes every transition write its event to the outbox table too.
+	outbox bool
+
+	observers []test.TransitionObserver
 }
 
-func New(cfg *config.Config, log *slog.Logger) (*Storage, error) {
+// New connects to the database of cfg. The buffers are laid out by layout,
+// table holds the source limits and priorities the buffers enforce.
+func New(cfg *config.Config, log *slog.Logger, table *sources.Table, layout *buffers.Layout) (*Storage, error) {
 	const op = "storage.postgres.New"
 	logger := log.With(slog.String("op", op))
+
+	var (
+		evictions []eviction.Policy
+		cursors   []int64
+	)
+	for _, b := range layout.Buffers() {
+		policy, err := eviction.New(b.EvictionPolicy, table)
+		if err != nil {
+			return nil, fmt.Errorf("%s: buffer %q: %w", op, b.Name, err)
+		}
+		evictions = append(evictions, policy)
+		cursors = append(cursors, b.Offset+1)
+	}
+
 	logger.Info("connecting to db")
-	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.PostgresConfig.Host,
-		cfg.PostgresConfig.Port,
-		cfg.PostgresConfig.Username,
-		cfg.PostgresConfig.Password,
-		cfg.PostgresConfig.DBName,
-		cfg.PostgresConfig.SSLMode))
+	db, err := open(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", "failed to open database connection", err)
+		return nil, err
 	}
 
-	stmt, err := db.Prepare("CREATE TABLE IF NOT EXISTS circular_buffer (" +
-		"pos integer PRIMARY KEY, source_number integer NOT NULL," +
-		"request_number integer NOT NULL," +
-		"arrival_time timestamp NOT NULL DEFAULT now());")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", "Can't prepare a circular_buffer table creation", err)
+	if cfg.PostgresConfig.AutoMigrate {
+		if _, err := migrations.Up(ctx, db, log); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %w", "Can't migrate database", err)
+		}
 	}
 
-	_, err = stmt.Exec()
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", "Can't create a circular_buffer table", err)
+	if err := migrations.Check(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// tests that were in flight when the previous run stopped have to be sent again
+	if _, err := db.ExecContext(ctx, "UPDATE circular_buffer SET in_flight = false WHERE in_flight;"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", "Can't release in-flight tests", err)
 	}
 
-	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS trash_table (" +
-		"source_number integer NOT NULL," +
-		"request_number integer NOT NULL," +
-		"arrival_time timestamp NOT NULL," +
-		"removal_time timestamp NOT NULL," +
-		"taken boolean NOT NULL DEFAULT false);")
+	logger.Info("successfully connected to db")
+
+	return &Storage{
+		db:        db,
+		log:       log,
+		layout:    layout,
+		cursors:   cursors,
+		evictions: evictions,
+		sources:   table,
+		outbox:    !cfg.OutboxConfig.Disabled,
+	}, nil
+}
+
+// Migrate applies pending schema migrations and returns how many were applied.
+func Migrate(cfg *config.Config, log *slog.Logger) (int, error) {
+	const op = "storage.postgres.Migrate"
 
+	db, err := open(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", "Can't prepare a trash_table table creation", err)
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	return migrations.Up(ctx, db, log)
+}
 
-	_, err = stmt.Exec()
+func open(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		cfg.PostgresConfig.Host,
+		cfg.PostgresConfig.Port,
+		cfg.PostgresConfig.Username,
+		cfg.PostgresConfig.Password,
+		cfg.PostgresConfig.DBName,
+		cfg.PostgresConfig.SSLMode))
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", "Can't create a trash_table table", err)
+		return nil, fmt.Errorf("%s: %w", "failed to open database connection", err)
 	}
 
-	logger.Info("successfully connected to db")
+	return db, nil
+}
 
-	return &Storage{db: db, log: log, maxSize: cfg.CycleBufferConfig.MaxSize, currId: 1}, nil
+func (st *Storage) Close() error {
+	return st.db.Close()
 }
 
 func (st *Storage) CheckAvailableSpace() (int64, error) {
-	stmt, err := st.db.Prepare("SELECT COUNT(*) FROM circular_buffer;")
+	// tests left outside the layout by a smaller configuration take no space
+	stmt, err := st.db.Prepare("SELECT COUNT(*) FROM circular_buffer WHERE pos < $1;")
 	if err != nil {
 		return 0, fmt.Errorf("Can't prepare a query to check available space: %w", err)
 	}
 	defer stmt.Close()
 
 	var count int64
-	err = stmt.QueryRow().Scan(&count)
+	err = stmt.QueryRow(st.layout.Size()).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("Can't check available space: %w", err)
 	}
 
-	return st.maxSize - count, nil
+	return st.layout.Size() - count, nil
 }
 
 func (st *Storage) GetMaxSize() int64 {
-	return st.maxSize
+	return st.layout.Size()
 }
 
 func (st *Storage) GetCurrId() int64 {
-	return st.currId
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.cursors[st.last]
 }
 
-func (st *Storage) SaveTest(test *test.TestRequest) error {
-	const op = "storage.postgres.SaveTest"
-	log := st.log.With(slog.String("op", op))
-	pos, err := st.db.Prepare("SELECT pos FROM circular_buffer;")
+func (st *Storage) SaveTest(req *test.TestRequest) error {
+	_, err := st.SaveTests([]test.TestRequest{*req})
+	return err
+}
+
+// SaveTests reads the buffer once and saves every request in a single
+// transaction.
+func (st *Storage) SaveTests(reqs []test.TestRequest) ([]test.SaveResult, error) {
+	const op = "storage.postgres.SaveTests"
+
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	positions := make(map[int64]bool, st.maxSize)
-	rows, err := pos.Query()
+	tx, err := st.begin(ctx)
 	if err != nil {
-		fmt.Errorf("%s: %w", "Can't prepare a query", err)
+		return nil, fmt.Errorf("begin transaction error: %v", err)
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	for rows.Next() {
-		var p int64
-		if err := rows.Scan(&p); err != nil {
-			fmt.Errorf("Can't get value: %w", err)
+	buffered, err := st.bufferedTests(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	occupied := make(map[int64]test.BufferedTest, len(buffered))
+	for _, t := range buffered {
+		occupied[t.Pos] = t
+	}
+
+	// the cursors move only once the transaction is committed
+	cur := writeCursors{pos: append([]int64(nil), st.cursors...), last: st.last}
+	results := make([]test.SaveResult, 0, len(reqs))
+	for i := range reqs {
+		result, err := st.saveTest(ctx, tx, occupied, &cur, &reqs[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
 		}
-		positions[p] = true
+		results = append(results, result)
 	}
 
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("row iteration error: %v", err)
-	}
-
-	if len(positions) > 0 {
-		var counter int64 = 0
-		for counter <= st.maxSize {
-			st.log.Debug("Current position:", slog.Any("pos", st.currId), slog.Any("value", positions[st.currId]))
-			if st.currId == st.maxSize {
-				st.currId = 0
-			}
-			if !positions[st.currId] {
-				break
-			}
-			counter++
-			st.currId++
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit error: %v", err)
+	}
+	st.cursors, st.last = cur.pos, cur.last
+
+	return results, nil
+}
+
+// writeCursors are the cursors of the buffers and the buffer written last as
+// of a transaction.
+type writeCursors struct {
+	pos  []int64
+	last int
+}
+
+// saveTest records req as received and puts it at the next free position
+// after the cursor of the buffer its source is routed to. occupied is the
+// buffer content and cur the cursors as of tx, both are kept up to date.
+// st.mu must be held.
+func (st *Storage) saveTest(ctx context.Context, tx *lifecycleTx, occupied map[int64]test.BufferedTest, cur *writeCursors, req *test.TestRequest) (test.SaveResult, error) {
+	log := st.log.With(slog.String("op", "storage.postgres.SaveTest"))
+
+	if err := tx.record(ctx, req.SourceID, req.TestNumber, test.Transition{State: test.StateReceived}); err != nil {
+		return test.SaveResult{}, fmt.Errorf("Can't record test: %w", err)
+	}
+
+	idx := st.layout.Route(req.SourceID)
+	b := st.layout.At(idx)
+
+	var held int64
+	for pos, t := range occupied {
+		if b.Contains(pos) && t.SourceID == req.SourceID {
+			held++
 		}
-		st.log.Debug("Checking counter", slog.Any("counter", counter))
-		if counter > st.maxSize {
-			st.log.Debug("Moving test to trash table")
-			err := st.moveToTrash()
-			if err != nil {
-				fmt.Errorf("%s: %w", "Can't move to trash", err)
-			}
+	}
+	atShare := held >= st.sources.Cap(req.SourceID, b.Size)
+
+	var result test.SaveResult
+	pos, free := b.Free(cur.pos[idx], func(pos int64) bool {
+		_, taken := occupied[pos]
+		return taken
+	})
+	if !free || atShare {
+		st.log.Debug("Buffer is full, applying eviction policy", slog.String("buffer", b.Name), slog.Bool("at_share", atShare))
+		victim, ok, err := st.moveToTrash(ctx, tx, occupied, req, idx, atShare)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", "Can't move to trash", err)
+		}
+		if !ok {
+			log.Info("Test refused by eviction policy",
+				"source_number", req.SourceID,
+				"test_number", req.TestNumber,
+				"buffer", b.Name,
+			)
+			return test.SaveResult{Rejected: true}, nil
 		}
+		result.Evicted = &victim.TestRequest
+		pos = victim.Pos
 	}
 
-	stmt, err := st.db.Prepare("INSERT INTO circular_buffer (pos, source_number, request_number) VALUES ($1, $2, $3);")
+	log.Info("Saving test",
+		"source_number", req.SourceID,
+		"test_number", req.TestNumber,
+		"buffer", b.Name,
+	)
+
+	var arrival time.Time
+	err := tx.QueryRowContext(ctx,
+		"INSERT INTO circular_buffer (pos, source_number, request_number) VALUES ($1, $2, $3) RETURNING arrival_time;",
+		pos, req.SourceID, req.TestNumber,
+	).Scan(&arrival)
 	if err != nil {
-		return fmt.Errorf("Can't prepare a query to save test: %w", err)
+		return result, fmt.Errorf("Can't save test: %w", err)
 	}
 
-	defer stmt.Close()
+	err = tx.record(ctx, req.SourceID, req.TestNumber, test.Transition{State: test.StateBuffered, Pos: &pos})
+	if err != nil {
+		return result, fmt.Errorf("Can't save test: %w", err)
+	}
 
-	log.Info("Saving test",
-		"source_number", test.SourceID,
-		"test_number", test.TestNumber,
+	occupied[pos] = test.BufferedTest{TestRequest: *req, Buffer: b.Name, Pos: pos, ArrivalTime: arrival}
+	cur.pos[idx] = pos
+	cur.last = idx
+	st.log.Debug("Sent test to buffer", slog.Any("pos", pos), slog.Any("sourse id", req.SourceID), slog.Any("test number", req.TestNumber))
+
+	result.Pos = pos
+	return result, nil
+}
+
+// moveToTrash asks the eviction policy of buffer idx which of its tests has
+// to leave and moves it to trash_table. When the policy refuses the incoming
+// test instead, incoming itself lands in trash_table and false is returned.
+// With atShare only tests of the incoming source may be evicted.
+func (st *Storage) moveToTrash(ctx context.Context, tx *lifecycleTx, occupied map[int64]test.BufferedTest, incoming *test.TestRequest, idx int, atShare bool) (test.BufferedTest, bool, error) {
+	b := st.layout.At(idx)
+	evictable := make([]test.BufferedTest, 0, len(occupied))
+	for _, t := range occupied {
+		if b.Contains(t.Pos) && !t.InFlight && (!atShare || t.SourceID == incoming.SourceID) {
+			evictable = append(evictable, t)
+		}
+	}
+	sort.Slice(evictable, func(i, j int) bool {
+		return evictable[i].Pos < evictable[j].Pos
+	})
+
+	victim, ok := st.evictions[idx].Victim(evictable)
+	if !ok {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO trash_table 
+         (source_number, request_number, reason, arrival_time, removal_time) 
+         VALUES ($1, $2, $3, NOW(), NOW())`,
+			incoming.SourceID, incoming.TestNumber, test.StateRejected,
+		)
+		if err != nil {
+			return victim, false, fmt.Errorf("insert error: %v", err)
+		}
+
+		err = tx.record(ctx, incoming.SourceID, incoming.TestNumber, test.Transition{
+			State:  test.StateRejected,
+			Detail: eviction.Detail(atShare),
+		})
+		if err != nil {
+			return victim, false, err
+		}
+
+		return victim, false, nil
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO trash_table 
+         (source_number, request_number, reason, arrival_time, removal_time) 
+         VALUES ($1, $2, $3, $4, NOW())`,
+		victim.SourceID, victim.TestNumber, test.StateEvicted, victim.ArrivalTime,
+	)
+	if err != nil {
+		return victim, false, fmt.Errorf("insert error: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM circular_buffer 
+         WHERE pos = $1`,
+		victim.Pos,
 	)
+	if err != nil {
+		return victim, false, fmt.Errorf("delete error: %v", err)
+	}
 
-	_, err = stmt.Exec(st.currId, test.SourceID, test.TestNumber)
+	err = tx.record(ctx, victim.SourceID, victim.TestNumber, test.Transition{
+		State:  test.StateEvicted,
+		Pos:    &victim.Pos,
+		Detail: fmt.Sprintf("replaced by %d/%d", incoming.SourceID, incoming.TestNumber),
+	})
 	if err != nil {
-		return fmt.Errorf("Can't save test: %w", err)
+		return victim, false, err
 	}
-	st.log.Debug("Sent test to buffer", slog.Any("pos", st.currId), slog.Any("sourse id", test.SourceID), slog.Any("test number", test.TestNumber))
 
-	log.Info("Test saved")
+	delete(occupied, victim.Pos)
+	return victim, true, nil
+}
 
-	return nil
+func (st *Storage) PendingRefusals(limit int) ([]test.TrashTest, error) {
+	const op = "storage.postgres.PendingRefusals"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := st.db.QueryContext(ctx,
+		`SELECT id, source_number, request_number, reason, arrival_time, removal_time
+         FROM trash_table
+         WHERE notified_at IS NULL
+         ORDER BY id
+         LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var refusals []test.TrashTest
+	for rows.Next() {
+		var t test.TrashTest
+		if err := rows.Scan(&t.ID, &t.SourceID, &t.TestNumber, &t.Reason, &t.ArrivalTime, &t.RemovalTime); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		refusals = append(refusals, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return refusals, nil
 }
 
-func (st *Storage) GetTrashTest() (*test.TrashTest, error) {
-	const op = "storage.postgres.GetTrashTest"
+func (st *Storage) MarkRefusalsNotified(ids []int64) error {
+	const op = "storage.postgres.MarkRefusalsNotified"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := st.db.ExecContext(ctx, "UPDATE trash_table SET notified_at = NOW() WHERE id = ANY($1);", pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
 
-	log := st.log.With(slog.String("op", op))
+func (st *Storage) RecordResult(r test.Result, tr test.Transition) error {
+	const op = "storage.postgres.RecordResult"
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tx, err := st.db.BeginTx(ctx, nil)
+	tx, err := st.begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
-	data := test.TrashTest{}
-	log.Info("Trying to delete taken rows")
-	_, err = tx.ExecContext(ctx, `DELETE FROM trash_table 
-         WHERE taken = true`,
+	if err := tx.record(ctx, r.SourceID, r.TestNumber, tr); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO result_queue
+         (source_number, request_number, device_id, passed, duration_ms, msg)
+         VALUES ($1, $2, $3, $4, $5, $6)`,
+		r.SourceID, r.TestNumber, r.DeviceID, r.Passed, r.Duration.Milliseconds(), r.Msg,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	log.Info("Taken rows deleted")
 
-	log.Info("Trying to take new trash rows")
-	err = tx.QueryRowContext(ctx,
-		"SELECT source_number, request_number, arrival_time, removal_time FROM trash_table WHERE taken = false;",
-	).Scan(&data.SourceID, &data.TestNumber, &data.ArrivalTime, &data.RemovalTime)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (st *Storage) PendingResults(limit int) ([]test.Result, error) {
+	const op = "storage.postgres.PendingResults"
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := st.db.QueryContext(ctx,
+		`SELECT id, source_number, request_number, device_id, passed, duration_ms, msg
+         FROM result_queue
+         WHERE sent_at IS NULL
+         ORDER BY id
+         LIMIT $1`,
+		limit,
+	)
 	if err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var results []test.Result
+	for rows.Next() {
+		var r test.Result
+		var durationMs int64
+		if err := rows.Scan(&r.ID, &r.SourceID, &r.TestNumber, &r.DeviceID, &r.Passed, &durationMs, &r.Msg); err != nil {
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
+		r.Duration = time.Duration(durationMs) * time.Millisecond
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("Trash data was taken")
+	return results, nil
+}
 
-	_, err = tx.ExecContext(ctx,
-		`UPDATE trash_table 
-         SET taken = true 
-         WHERE taken = false`)
+func (st *Storage) MarkResultsSent(ids []int64) error {
+	const op = "storage.postgres.MarkResultsSent"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := st.db.ExecContext(ctx, "UPDATE result_queue SET sent_at = NOW() WHERE id = ANY($1);", pq.Array(ids))
 	if err != nil {
-		st.log.Error("update error: ", err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
+	return nil
+}
 
-	if err = tx.Commit(); err != nil {
-		st.log.Error("commit error: ", err)
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (st *Storage) bufferedTests(ctx context.Context, q querier) ([]test.BufferedTest, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT pos, source_number, request_number, arrival_time, attempts, in_flight,
+                NOT in_flight AND next_attempt_at <= now()
+         FROM circular_buffer`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("select error: %v", err)
+	}
+	defer rows.Close()
+
+	var buffered []test.BufferedTest
+	for rows.Next() {
+		var t test.BufferedTest
+		if err := rows.Scan(&t.Pos, &t.SourceID, &t.TestNumber, &t.ArrivalTime, &t.Attempts, &t.InFlight, &t.Ready); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+		t.Buffer = st.layout.Name(t.Pos)
+		buffered = append(buffered, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %v", err)
+	}
+
+	return buffered, nil
+}
+
+func (st *Storage) ListTests() ([]test.BufferedTest, error) {
+	const op = "storage.postgres.ListTests"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	buffered, err := st.bufferedTests(ctx, st.db)
+	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &data, nil
+	return buffered, nil
 }
 
-func (st *Storage) moveToTrash() error {
+// GetTest returns the next test to dispatch: by priority class, then by
+// source and request number. The classes live in the config, so the sorting
+// is done here and not in the query.
+func (st *Storage) GetTest() (int64, int64, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tx, err := st.db.BeginTx(ctx, nil)
+	buffered, err := st.bufferedTests(ctx, st.db)
 	if err != nil {
-		return fmt.Errorf("begin transaction error: %v", err)
+		return 0, 0, 0, fmt.Errorf("error querying record: %v", err)
+	}
+
+	var next *test.BufferedTest
+	for i, t := range buffered {
+		if t.InFlight {
+			continue
+		}
+		if next == nil || st.sources.Less(t.SourceID, next.SourceID) ||
+			t.SourceID == next.SourceID && t.TestNumber < next.TestNumber {
+			next = &buffered[i]
+		}
+	}
+	if next == nil {
+		return -1, -1, -1, nil
+	}
+
+	return next.Pos, int64(next.SourceID), int64(next.TestNumber), nil
+}
+
+func (st *Storage) DeleteTest(pos int64) error {
+	_, err := st.db.Exec(
+		`DELETE FROM circular_buffer 
+         WHERE pos = $1`,
+		pos,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) MarkInFlight(pos int64) error {
+	const op = "storage.postgres.MarkInFlight"
+
+	res, err := st.db.Exec(
+		`UPDATE circular_buffer 
+         SET in_flight = true 
+         WHERE pos = $1 AND NOT in_flight`,
+		pos,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: pos %d: %w", op, pos, test.ErrTestNotFound)
+	}
+
+	return nil
+}
+
+func (st *Storage) RetryTest(pos int64, backoff time.Duration, maxAttempts int, reason string) (bool, error) {
+	const op = "storage.postgres.RetryTest"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := st.begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
 	var (
-		currentID     int64
-		sourceNumber  int
-		requestNumber int
-		arrivalTime   time.Time
+		attempts     int
+		sourceNumber int
+		requestNum   int
+		arrivalTime  time.Time
 	)
-
 	err = tx.QueryRowContext(ctx,
-		`SELECT pos, source_number, request_number, arrival_time 
-         FROM circular_buffer 
-         ORDER BY source_number, request_number LIMIT 1`,
-	).Scan(&currentID, &sourceNumber, &requestNumber, &arrivalTime)
+		`UPDATE circular_buffer 
+         SET in_flight = false, attempts = attempts + 1,
+             next_attempt_at = now() + $2 * interval '1 millisecond'
+         WHERE pos = $1 AND in_flight
+         RETURNING attempts, source_number, request_number, arrival_time`,
+		pos, backoff.Milliseconds(),
+	).Scan(&attempts, &sourceNumber, &requestNum, &arrivalTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("%s: pos %d: %w", op, pos, test.ErrTestNotFound)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
 
+	err = tx.record(ctx, uint(sourceNumber), uint(requestNum), test.Transition{
+		State:  test.StateSendFailed,
+		Pos:    &pos,
+		Detail: reason,
+	})
 	if err != nil {
-		return fmt.Errorf("select error: %v", err)
+		return false, fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO trash_table 
-         (source_number, request_number, arrival_time, removal_time) 
-         VALUES ($1, $2, $3, NOW())`,
-		sourceNumber, requestNumber, arrivalTime,
-	)
+	dead := attempts >= maxAttempts
+	if dead {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO dead_letter 
+             (source_number, request_number, arrival_time, attempts, last_error) 
+             VALUES ($1, $2, $3, $4, $5)`,
+			sourceNumber, requestNum, arrivalTime, attempts, reason,
+		)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM circular_buffer WHERE pos = $1`, pos)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		err = tx.record(ctx, uint(sourceNumber), uint(requestNum), test.Transition{
+			State:  test.StateDeadLettered,
+			Detail: fmt.Sprintf("%d attempts", attempts),
+		})
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return dead, nil
+}
+
+func (st *Storage) DispatchTest(pos int64, deviceID int32) error {
+	const op = "storage.postgres.DispatchTest"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := st.begin(ctx)
 	if err != nil {
-		return fmt.Errorf("insert error: %v", err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
+	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx,
+	var sourceNumber, requestNum uint
+	err = tx.QueryRowContext(ctx,
 		`DELETE FROM circular_buffer 
-         WHERE pos = $1`,
-		currentID,
-	)
+         WHERE pos = $1 AND in_flight
+         RETURNING source_number, request_number`,
+		pos,
+	).Scan(&sourceNumber, &requestNum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: pos %d: %w", op, pos, test.ErrTestNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = tx.record(ctx, sourceNumber, requestNum, test.Transition{
+		State:    test.StateDispatched,
+		Pos:      &pos,
+		DeviceID: &deviceID,
+	})
 	if err != nil {
-		return fmt.Errorf("delete error: %v", err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("commit error: %v", err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	st.currId = currentID
 	return nil
 }
 
-func (st *Storage) GetTest() (int64, int64, int64, error) {
-	query := `
-        SELECT pos, source_number, request_number 
-        FROM circular_buffer 
-        ORDER BY source_number, request_number 
-        LIMIT 1`
+func (st *Storage) RecordTransition(sourceID, testNumber uint, tr test.Transition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	var pos, source, request int64
+	const op = "storage.postgres.RecordTransition"
 
-	// Выполняем запрос и сканируем результат
-	err := st.db.QueryRow(query).Scan(&pos, &source, &request)
+	tx, err := st.begin(ctx)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return -1, -1, -1, nil
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := tx.record(ctx, sourceID, testNumber, tr); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (st *Storage) RecordTransitions(records []test.Record) error {
+	const op = "storage.postgres.RecordTransitions"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := st.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		if err := tx.record(ctx, r.SourceID, r.TestNumber, r.Transition); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
 		}
-		return 0, 0, 0, fmt.Errorf("error querying record: %v", err)
 	}
 
-	return pos, source, request, nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
 }
 
-func (st *Storage) DeleteTest(pos int64) error {
-	_, err := st.db.Exec(
-		`DELETE FROM circular_buffer 
-         WHERE pos = $1`,
-		pos,
+func (st *Storage) GetLifecycle(sourceID, testNumber uint) ([]test.Transition, error) {
+	const op = "storage.postgres.GetLifecycle"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := st.db.QueryContext(ctx,
+		`SELECT state, pos, device_id, detail, at 
+         FROM request_lifecycle 
+         WHERE source_number = $1 AND request_number = $2 
+         ORDER BY id`,
+		sourceID, testNumber,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var transitions []test.Transition
+	for rows.Next() {
+		var (
+			tr       test.Transition
+			pos      sql.NullInt64
+			deviceID sql.NullInt32
+		)
+		if err := rows.Scan(&tr.State, &pos, &deviceID, &tr.Detail, &tr.At); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if pos.Valid {
+			tr.Pos = &pos.Int64
+		}
+		if deviceID.Valid {
+			tr.DeviceID = &deviceID.Int32
+		}
+		transitions = append(transitions, tr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return transitions, nil
+}
+
+// PendingOutbox returns up to limit unsent outbox messages, oldest first.
+func (st *Storage) PendingOutbox(limit int) ([]outbox.Message, error) {
+	const op = "storage.postgres.PendingOutbox"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := st.db.QueryContext(ctx,
+		`SELECT id, key, payload 
+         FROM outbox 
+         WHERE sent_at IS NULL 
+         ORDER BY id 
+         LIMIT $1`,
+		limit,
 	)
 	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var msgs []outbox.Message
+	for rows.Next() {
+		var m outbox.Message
+		if err := rows.Scan(&m.ID, &m.Key, &m.Payload); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		msgs = append(msgs, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return msgs, nil
+}
+
+// MarkOutboxSent marks outbox messages as delivered.
+func (st *Storage) MarkOutboxSent(ids []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := st.db.ExecContext(ctx, "UPDATE outbox SET sent_at = now() WHERE id = ANY($1);", pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("storage.postgres.MarkOutboxSent: %w", err)
+	}
+	return nil
+}
+
+func (st *Storage) Subscribe(o test.TransitionObserver) {
+	st.observers = append(st.observers, o)
+}
+
+func (st *Storage) notify(sourceID, testNumber uint, tr test.Transition) {
+	for _, o := range st.observers {
+		o.Observe(sourceID, testNumber, tr)
+	}
+}
+
+type observed struct {
+	sourceID, testNumber uint
+	tr                   test.Transition
+}
+
+// lifecycleTx is a transaction that records lifecycle transitions and hands
+// them to the observers only once it is committed.
+type lifecycleTx struct {
+	*sql.Tx
+	st      *Storage
+	pending []observed
+}
+
+func (st *Storage) begin(ctx context.Context) (*lifecycleTx, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &lifecycleTx{Tx: tx, st: st}, nil
+}
+
+func (tx *lifecycleTx) record(ctx context.Context, sourceID, testNumber uint, tr test.Transition) error {
+	if err := recordTransition(ctx, tx.Tx, sourceID, testNumber, tr); err != nil {
+		return err
+	}
+	tr.At = time.Now()
+
+	if tx.st.outbox {
+		key, ev := events.FromTransition(sourceID, testNumber, tr)
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("outbox marshal error: %v", err)
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO outbox (key, payload) VALUES ($1, $2);", key, payload)
+		if err != nil {
+			return fmt.Errorf("outbox insert error: %v", err)
+		}
+	}
+
+	tx.pending = append(tx.pending, observed{sourceID, testNumber, tr})
+	return nil
+}
+
+func (tx *lifecycleTx) Commit() error {
+	if err := tx.Tx.Commit(); err != nil {
 		return err
 	}
+	for _, o := range tx.pending {
+		tx.st.notify(o.sourceID, o.testNumber, o.tr)
+	}
+	return nil
+}
+
+// recordTransition stores tr with the database time, tr.At is ignored.
+func recordTransition(ctx context.Context, e execer, sourceID, testNumber uint, tr test.Transition) error {
+	_, err := e.ExecContext(ctx,
+		`INSERT INTO request_lifecycle 
+         (source_number, request_number, state, pos, device_id, detail) 
+         VALUES ($1, $2, $3, $4, $5, $6)`,
+		sourceID, testNumber, tr.State, tr.Pos, tr.DeviceID, tr.Detail,
+	)
+	if err != nil {
+		return fmt.Errorf("lifecycle insert error: %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/events"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/storage/postgres/migrations"
+	"Dispatcher/internal/storage/storagetest"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+// testPostgres returns connection settings of a locally started Postgres.
+// The suite is skipped unless TEST_POSTGRES_HOST is set.
+func testPostgres(t *testing.T) config.PostgresConfig {
+	t.Helper()
+
+	host := os.Getenv("TEST_POSTGRES_HOST")
+	if host == "" {
+		t.Skip("TEST_POSTGRES_HOST is not set, skipping Postgres tests")
+	}
+
+	return config.PostgresConfig{
+		Host:     host,
+		Port:     getenv("TEST_POSTGRES_PORT", "5432"),
+		Username: getenv("TEST_POSTGRES_USER", "postgres"),
+		Password: getenv("TEST_POSTGRES_PASSWORD", "password"),
+		DBName:   getenv("TEST_POSTGRES_DB", "postgres"),
+		SSLMode:  getenv("TEST_POSTGRES_SSLMODE", "disable"),
+	}
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// openTestDB connects to the test Postgres, skipping the test when it is down.
+func openTestDB(t *testing.T, pg config.PostgresConfig) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		pg.Host, pg.Port, pg.Username, pg.Password, pg.DBName, pg.SSLMode))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Skipf("Postgres is not available: %v", err)
+	}
+	return db
+}
+
+// resetTables drops every table, leaving an empty database.
+func resetTables(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	if _, err := db.Exec("DROP TABLE IF EXISTS circular_buffer, trash_table, dead_letter, request_lifecycle, outbox, result_queue, schema_version;"); err != nil {
+		t.Fatalf("reset tables: %v", err)
+	}
+}
+
+// newTestStorage drops all tables and returns a freshly migrated storage.
+func newTestStorage(t *testing.T, db *sql.DB, pg config.PostgresConfig, cfg *config.Config) *Storage {
+	t.Helper()
+
+	resetTables(t, db)
+
+	cfg.PostgresConfig = pg
+	cfg.PostgresConfig.AutoMigrate = true
+	table, layout := storagetest.Layout(t, cfg)
+	st, err := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), table, layout)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return st
+}
+
+func TestConformance(t *testing.T) {
+	pg := testPostgres(t)
+	db := openTestDB(t, pg)
+
+	storagetest.Run(t, func(t *testing.T, cfg *config.Config) test.TestCycleBuffer {
+		return newTestStorage(t, db, pg, cfg)
+	})
+}
+
+func TestReopen(t *testing.T) {
+	pg := testPostgres(t)
+	db := openTestDB(t, pg)
+
+	storagetest.RunReopen(t,
+		func(t *testing.T, cfg *config.Config) test.TestCycleBuffer {
+			return newTestStorage(t, db, pg, cfg)
+		},
+		func(t *testing.T, buf test.TestCycleBuffer, cfg *config.Config) test.TestCycleBuffer {
+			buf.(*Storage).Close()
+			cfg.PostgresConfig = pg
+			table, layout := storagetest.Layout(t, cfg)
+			st, err := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), table, layout)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			return st
+		})
+}
+
+func TestOutbox(t *testing.T) {
+	pg := testPostgres(t)
+	db := openTestDB(t, pg)
+
+	st := newTestStorage(t, db, pg, &config.Config{
+		CycleBufferConfig: config.CycleBufferConfig{MaxSize: 1},
+	})
+
+	if err := st.SaveTest(&test.TestRequest{SourceID: 1, TestNumber: 1}); err != nil {
+		t.Fatalf("SaveTest: %v", err)
+	}
+	if err := st.SaveTest(&test.TestRequest{SourceID: 1, TestNumber: 2}); err != nil {
+		t.Fatalf("SaveTest: %v", err)
+	}
+
+	pending, err := st.PendingOutbox(10)
+	if err != nil {
+		t.Fatalf("PendingOutbox: %v", err)
+	}
+
+	want := []string{
+		events.RequestReceived, events.RequestBuffered,
+		events.RequestReceived, events.RequestEvicted, events.RequestBuffered,
+	}
+	if len(pending) != len(want) {
+		t.Fatalf("outbox has %d messages, want %d", len(pending), len(want))
+	}
+	for i, m := range pending {
+		var ev events.Event
+		if err := json.Unmarshal(m.Payload, &ev); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		if ev.Type != want[i] {
+			t.Errorf("outbox message %d is %s, want %s", i, ev.Type, want[i])
+		}
+		if i > 0 && m.ID <= pending[i-1].ID {
+			t.Errorf("outbox messages are not ordered by id: %d after %d", m.ID, pending[i-1].ID)
+		}
+	}
+
+	if err := st.MarkOutboxSent([]int64{pending[0].ID, pending[1].ID}); err != nil {
+		t.Fatalf("MarkOutboxSent: %v", err)
+	}
+	left, err := st.PendingOutbox(10)
+	if err != nil {
+		t.Fatalf("PendingOutbox: %v", err)
+	}
+	if len(left) != 3 || left[0].ID != pending[2].ID {
+		t.Errorf("after marking two sent outbox has %+v", left)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	pg := testPostgres(t)
+	db := openTestDB(t, pg)
+	resetTables(t, db)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{PostgresConfig: pg, CycleBufferConfig: config.CycleBufferConfig{MaxSize: 1}}
+	table, layout := storagetest.Layout(t, cfg)
+
+	// without auto_migrate an empty database is refused and left untouched
+	if _, err := New(cfg, log, table, layout); !errors.Is(err, migrations.ErrSchemaOutdated) {
+		t.Fatalf("New on an empty database = %v, want %v", err, migrations.ErrSchemaOutdated)
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass('schema_version') IS NOT NULL;").Scan(&exists); err != nil {
+		t.Fatalf("look up schema_version: %v", err)
+	}
+	if exists {
+		t.Errorf("the schema check created schema_version")
+	}
+
+	latest, err := migrations.Latest()
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	applied, err := Migrate(cfg, log)
+	if err != nil || applied != latest {
+		t.Fatalf("Migrate = %d, %v, want %d applied", applied, err, latest)
+	}
+	if err := migrations.Check(context.Background(), db); err != nil {
+		t.Errorf("Check after Migrate: %v", err)
+	}
+	if applied, err := Migrate(cfg, log); err != nil || applied != 0 {
+		t.Errorf("second Migrate = %d, %v, want nothing applied", applied, err)
+	}
+
+	st, err := New(cfg, log, table, layout)
+	if err != nil {
+		t.Fatalf("New after Migrate: %v", err)
+	}
+	st.Close()
+}
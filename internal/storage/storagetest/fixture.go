@@ -0,0 +1,20 @@
+package storagetest
+
+import (
+	"Dispatcher/internal/http-server/handlers/test"
+	"time"
+)
+
+// Buffered returns the buffer content the policy tests share: three sources
+// interleaved, arriving in a different order than their positions.
+func Buffered() []test.BufferedTest {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	return []test.BufferedTest{
+		{Pos: 0, TestRequest: test.TestRequest{SourceID: 2, TestNumber: 7}, ArrivalTime: base.Add(2 * time.Second)},
+		{Pos: 1, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 9}, ArrivalTime: base.Add(3 * time.Second)},
+		{Pos: 2, TestRequest: test.TestRequest{SourceID: 3, TestNumber: 4}, ArrivalTime: base},
+		{Pos: 3, TestRequest: test.TestRequest{SourceID: 1, TestNumber: 5}, ArrivalTime: base.Add(4 * time.Second)},
+		{Pos: 4, TestRequest: test.TestRequest{SourceID: 3, TestNumber: 6}, ArrivalTime: base.Add(time.Second)},
+	}
+}
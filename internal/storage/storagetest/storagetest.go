@@ -0,0 +1,841 @@
+// Package storagetest contains a conformance suite that every
+// test.TestCycleBuffer implementation has to pass.
+package storagetest
+
+import (
+	"Dispatcher/internal/buffers"
+	"Dispatcher/internal/config"
+	"Dispatcher/internal/http-server/handlers/test"
+	"Dispatcher/internal/sources"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns a new empty buffer configured from cfg.
+type Factory func(t *testing.T, cfg *config.Config) test.TestCycleBuffer
+
+// Reopen returns a buffer over the data of buf configured from cfg, as after
+// a restart with a new configuration.
+type Reopen func(t *testing.T, buf test.TestCycleBuffer, cfg *config.Config) test.TestCycleBuffer
+
+// Layout builds the source table and the buffer layout of cfg the way the
+// entrypoint does.
+func Layout(t *testing.T, cfg *config.Config) (*sources.Table, *buffers.Layout) {
+	t.Helper()
+
+	table, err := sources.New(cfg.Sources, cfg.UnknownSources)
+	if err != nil {
+		t.Fatalf("sources.New: %v", err)
+	}
+	layout, err := buffers.New(cfg, table)
+	if err != nil {
+		t.Fatalf("buffers.New: %v", err)
+	}
+	return table, layout
+}
+
+// RunReopen runs the cases that restart a buffer with a different layout.
+func RunReopen(t *testing.T, newBuffer Factory, reopen Reopen) {
+	t.Run("ShrinkLayout", func(t *testing.T) { testShrinkLayout(t, newBuffer, reopen) })
+}
+
+// Run runs the whole suite against buffers produced by newBuffer.
+func Run(t *testing.T, newBuffer Factory) {
+	t.Run("FillToCapacity", func(t *testing.T) { testFillToCapacity(t, newBuffer) })
+	t.Run("OverflowEviction", func(t *testing.T) { testOverflowEviction(t, newBuffer) })
+	t.Run("OverflowReject", func(t *testing.T) { testOverflowReject(t, newBuffer) })
+	t.Run("SourceShare", func(t *testing.T) { testSourceShare(t, newBuffer) })
+	t.Run("NamedBuffers", func(t *testing.T) { testNamedBuffers(t, newBuffer) })
+	t.Run("GetTestOrdering", func(t *testing.T) { testGetTestOrdering(t, newBuffer) })
+	t.Run("DeleteTest", func(t *testing.T) { testDeleteTest(t, newBuffer) })
+	t.Run("Refusals", func(t *testing.T) { testRefusals(t, newBuffer) })
+	t.Run("Results", func(t *testing.T) { testResults(t, newBuffer) })
+	t.Run("InFlightRetry", func(t *testing.T) { testInFlightRetry(t, newBuffer) })
+	t.Run("InFlightNotEvicted", func(t *testing.T) { testInFlightNotEvicted(t, newBuffer) })
+	t.Run("Lifecycle", func(t *testing.T) { testLifecycle(t, newBuffer) })
+	t.Run("Observers", func(t *testing.T) { testObservers(t, newBuffer) })
+	t.Run("SaveTests", func(t *testing.T) { testSaveTests(t, newBuffer) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, newBuffer) })
+}
+
+func newConfig(maxSize int64, policy string) *config.Config {
+	return &config.Config{
+		CycleBufferConfig: config.CycleBufferConfig{
+			MaxSize:        maxSize,
+			EvictionPolicy: policy,
+		},
+	}
+}
+
+func save(t *testing.T, buf test.TestCycleBuffer, source, number uint) {
+	t.Helper()
+
+	if err := buf.SaveTest(&test.TestRequest{SourceID: source, TestNumber: number}); err != nil {
+		t.Fatalf("SaveTest(%d/%d): %v", source, number, err)
+	}
+}
+
+func available(t *testing.T, buf test.TestCycleBuffer) int64 {
+	t.Helper()
+
+	space, err := buf.CheckAvailableSpace()
+	if err != nil {
+		t.Fatalf("CheckAvailableSpace: %v", err)
+	}
+	return space
+}
+
+func list(t *testing.T, buf test.TestCycleBuffer) []test.BufferedTest {
+	t.Helper()
+
+	buffered, err := buf.ListTests()
+	if err != nil {
+		t.Fatalf("ListTests: %v", err)
+	}
+	return buffered
+}
+
+func refusals(t *testing.T, buf test.TestCycleBuffer) []test.TrashTest {
+	t.Helper()
+
+	pending, err := buf.PendingRefusals(100)
+	if err != nil {
+		t.Fatalf("PendingRefusals: %v", err)
+	}
+	return pending
+}
+
+func contains(buffered []test.BufferedTest, source, number uint) (test.BufferedTest, bool) {
+	for _, b := range buffered {
+		if b.SourceID == source && b.TestNumber == number {
+			return b, true
+		}
+	}
+	return test.BufferedTest{}, false
+}
+
+func testFillToCapacity(t *testing.T, newBuffer Factory) {
+	const maxSize = 4
+	buf := newBuffer(t, newConfig(maxSize, ""))
+
+	if got := buf.GetMaxSize(); got != maxSize {
+		t.Fatalf("GetMaxSize = %d, want %d", got, maxSize)
+	}
+	if got := available(t, buf); got != maxSize {
+		t.Fatalf("empty buffer available space = %d, want %d", got, maxSize)
+	}
+
+	for i := uint(1); i <= maxSize; i++ {
+		save(t, buf, 1, i)
+		if got, want := available(t, buf), maxSize-int64(i); got != want {
+			t.Fatalf("available space after %d saves = %d, want %d", i, got, want)
+		}
+	}
+
+	positions := make(map[int64]bool)
+	for _, b := range list(t, buf) {
+		if b.Pos < 0 || b.Pos >= maxSize {
+			t.Errorf("position %d is outside [0, %d)", b.Pos, maxSize)
+		}
+		if positions[b.Pos] {
+			t.Errorf("position %d is used twice", b.Pos)
+		}
+		positions[b.Pos] = true
+	}
+	if len(positions) != maxSize {
+		t.Errorf("buffer holds %d tests, want %d", len(positions), maxSize)
+	}
+}
+
+func testOverflowEviction(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(3, ""))
+
+	save(t, buf, 2, 1)
+	save(t, buf, 1, 2)
+	save(t, buf, 3, 1)
+	before, _ := contains(list(t, buf), 1, 2)
+
+	save(t, buf, 4, 1)
+
+	if got := available(t, buf); got != 0 {
+		t.Fatalf("available space after overflow = %d, want 0", got)
+	}
+
+	buffered := list(t, buf)
+	if len(buffered) != 3 {
+		t.Fatalf("buffer holds %d tests after overflow, want 3", len(buffered))
+	}
+	if _, ok := contains(buffered, 1, 2); ok {
+		t.Errorf("evicted test 1/2 is still buffered")
+	}
+	incoming, ok := contains(buffered, 4, 1)
+	if !ok {
+		t.Fatalf("incoming test 4/1 was not buffered")
+	}
+	if incoming.Pos != before.Pos {
+		t.Errorf("incoming test took position %d, want freed position %d", incoming.Pos, before.Pos)
+	}
+	if got := buf.GetCurrId(); got != before.Pos {
+		t.Errorf("GetCurrId = %d, want %d", got, before.Pos)
+	}
+
+	pending := refusals(t, buf)
+	if len(pending) != 1 {
+		t.Fatalf("pending refusals = %+v, want one", pending)
+	}
+	trash := pending[0]
+	if trash.SourceID != 1 || trash.TestNumber != 2 || trash.Reason != test.StateEvicted {
+		t.Errorf("trash test = %d/%d %s, want evicted 1/2", trash.SourceID, trash.TestNumber, trash.Reason)
+	}
+	if trash.RemovalTime.Before(trash.ArrivalTime) {
+		t.Errorf("removal time %v is before arrival time %v", trash.RemovalTime, trash.ArrivalTime)
+	}
+}
+
+func testOverflowReject(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(2, "reject"))
+
+	save(t, buf, 1, 1)
+	save(t, buf, 1, 2)
+	save(t, buf, 1, 3)
+
+	buffered := list(t, buf)
+	if len(buffered) != 2 {
+		t.Fatalf("buffer holds %d tests, want 2", len(buffered))
+	}
+	if _, ok := contains(buffered, 1, 3); ok {
+		t.Errorf("refused test 1/3 was buffered")
+	}
+
+	pending := refusals(t, buf)
+	if len(pending) != 1 {
+		t.Fatalf("pending refusals = %+v, want one", pending)
+	}
+	if trash := pending[0]; trash.SourceID != 1 || trash.TestNumber != 3 || trash.Reason != test.StateRejected {
+		t.Errorf("trash test = %d/%d %s, want rejected 1/3", trash.SourceID, trash.TestNumber, trash.Reason)
+	}
+}
+
+func testSourceShare(t *testing.T, newBuffer Factory) {
+	shared := func(policy string) *config.Config {
+		cfg := newConfig(4, policy)
+		cfg.Sources = []config.Source{{ID: 1, MaxShare: 0.5}}
+		return cfg
+	}
+
+	t.Run("Evict", func(t *testing.T) {
+		buf := newBuffer(t, shared(""))
+
+		save(t, buf, 1, 1)
+		save(t, buf, 1, 2)
+		save(t, buf, 1, 3)
+
+		if got := available(t, buf); got != 2 {
+			t.Fatalf("available space = %d, want 2 with source 1 at its share", got)
+		}
+		buffered := list(t, buf)
+		if _, ok := contains(buffered, 1, 1); ok {
+			t.Errorf("test 1/1 is still buffered, want it evicted for 1/3")
+		}
+		if _, ok := contains(buffered, 1, 3); !ok {
+			t.Errorf("incoming test 1/3 was not buffered")
+		}
+
+		// other sources still use the rest of the buffer
+		save(t, buf, 2, 1)
+		save(t, buf, 2, 2)
+		if got := available(t, buf); got != 0 {
+			t.Errorf("available space = %d, want 0", got)
+		}
+
+		pending := refusals(t, buf)
+		if len(pending) != 1 || pending[0].SourceID != 1 || pending[0].TestNumber != 1 || pending[0].Reason != test.StateEvicted {
+			t.Errorf("pending refusals = %+v, want evicted 1/1", pending)
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		buf := newBuffer(t, shared("reject"))
+
+		save(t, buf, 1, 1)
+		save(t, buf, 1, 2)
+		save(t, buf, 1, 3)
+
+		if got := available(t, buf); got != 2 {
+			t.Fatalf("available space = %d, want 2", got)
+		}
+		if _, ok := contains(list(t, buf), 1, 3); ok {
+			t.Errorf("test 1/3 over the share of source 1 was buffered")
+		}
+
+		pending := refusals(t, buf)
+		if len(pending) != 1 || pending[0].TestNumber != 3 || pending[0].Reason != test.StateRejected {
+			t.Fatalf("pending refusals = %+v, want rejected 1/3", pending)
+		}
+		got := states(t, buf, 1, 3)
+		if len(got) == 0 || got[len(got)-1] != test.StateRejected {
+			t.Errorf("lifecycle of 1/3 = %v, want it to end rejected", got)
+		}
+	})
+}
+
+func testNamedBuffers(t *testing.T, newBuffer Factory) {
+	cfg := &config.Config{
+		Buffers: []config.Buffer{
+			{Name: "bulk", MaxSize: 3, EvictionPolicy: "reject"},
+			{Name: "urgent", MaxSize: 2, EvictionPolicy: "oldest"},
+		},
+		Routes: []config.Route{{Buffer: "urgent", Sources: []uint{7}}},
+	}
+	buf := newBuffer(t, cfg)
+
+	if got := buf.GetMaxSize(); got != 5 {
+		t.Fatalf("GetMaxSize = %d, want the sum of the buffers", got)
+	}
+
+	save(t, buf, 7, 1)
+	save(t, buf, 1, 1)
+	save(t, buf, 7, 2)
+	save(t, buf, 7, 3)
+
+	buffered := list(t, buf)
+	for _, b := range buffered {
+		want, lo, hi := "bulk", int64(0), int64(3)
+		if b.SourceID == 7 {
+			want, lo, hi = "urgent", 3, 5
+		}
+		if b.Buffer != want || b.Pos < lo || b.Pos >= hi {
+			t.Errorf("test %d/%d is at %s/%d, want %s within [%d, %d)", b.SourceID, b.TestNumber, b.Buffer, b.Pos, want, lo, hi)
+		}
+	}
+
+	// urgent is full and evicts its oldest, bulk still has room
+	if _, ok := contains(buffered, 7, 1); ok {
+		t.Errorf("oldest urgent test 7/1 is still buffered")
+	}
+	if got := available(t, buf); got != 2 {
+		t.Errorf("available space = %d, want 2", got)
+	}
+
+	save(t, buf, 1, 2)
+	save(t, buf, 1, 3)
+	save(t, buf, 1, 4)
+
+	// bulk rejects once full, urgent is left alone
+	if _, ok := contains(list(t, buf), 1, 4); ok {
+		t.Errorf("test 1/4 was buffered in a full bulk buffer")
+	}
+	pending := refusals(t, buf)
+	if len(pending) != 2 || pending[0].Reason != test.StateEvicted || pending[1].TestNumber != 4 || pending[1].Reason != test.StateRejected {
+		t.Errorf("pending refusals = %+v, want evicted 7/1 and rejected 1/4", pending)
+	}
+}
+
+func testShrinkLayout(t *testing.T, newBuffer Factory, reopen Reopen) {
+	buf := newBuffer(t, newConfig(4, "oldest"))
+	for i := uint(1); i <= 4; i++ {
+		save(t, buf, 1, i)
+	}
+	before := list(t, buf)
+
+	buf = reopen(t, buf, newConfig(2, "oldest"))
+
+	// the two tests outside the smaller buffer are still there but take no space
+	if got := list(t, buf); len(got) != 4 {
+		t.Fatalf("after the restart %d tests are buffered, want 4", len(got))
+	}
+	if got := available(t, buf); got != 0 {
+		t.Fatalf("available space = %d, want 0", got)
+	}
+
+	var orphan, oldest test.BufferedTest
+	for _, b := range before {
+		if b.Pos >= 2 {
+			orphan = b
+		} else if oldest.TestNumber == 0 || b.TestNumber < oldest.TestNumber {
+			oldest = b
+		}
+	}
+	if err := buf.DeleteTest(orphan.Pos); err != nil {
+		t.Fatalf("DeleteTest: %v", err)
+	}
+	if got := available(t, buf); got != 0 {
+		t.Errorf("available space after an orphan left = %d, want 0", got)
+	}
+
+	save(t, buf, 1, 5)
+	buffered := list(t, buf)
+	if _, ok := contains(buffered, 1, oldest.TestNumber); ok {
+		t.Errorf("1/%d was not evicted from the smaller buffer", oldest.TestNumber)
+	}
+	if got, ok := contains(buffered, 1, 5); !ok || got.Pos != oldest.Pos {
+		t.Errorf("1/5 = %+v, want it at position %d", got, oldest.Pos)
+	}
+	if got := available(t, buf); got != 0 {
+		t.Errorf("available space = %d, want 0", got)
+	}
+}
+
+func testGetTestOrdering(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(5, ""))
+
+	pos, source, number, err := buf.GetTest()
+	if err != nil {
+		t.Fatalf("GetTest on empty buffer: %v", err)
+	}
+	if pos != -1 || source != -1 || number != -1 {
+		t.Fatalf("GetTest on empty buffer = %d, %d, %d, want -1, -1, -1", pos, source, number)
+	}
+
+	save(t, buf, 2, 5)
+	save(t, buf, 1, 7)
+	save(t, buf, 3, 1)
+	save(t, buf, 1, 3)
+
+	want := [][2]int64{{1, 3}, {1, 7}, {2, 5}, {3, 1}}
+	for _, w := range want {
+		pos, source, number, err := buf.GetTest()
+		if err != nil {
+			t.Fatalf("GetTest: %v", err)
+		}
+		if source != w[0] || number != w[1] {
+			t.Fatalf("GetTest = %d/%d, want %d/%d", source, number, w[0], w[1])
+		}
+		if err := buf.DeleteTest(pos); err != nil {
+			t.Fatalf("DeleteTest(%d): %v", pos, err)
+		}
+	}
+
+	if pos, _, _, _ := buf.GetTest(); pos != -1 {
+		t.Errorf("GetTest on drained buffer returned position %d", pos)
+	}
+
+	// source 2 is in a higher priority class than source 1
+	cfg := newConfig(5, "")
+	cfg.Sources = []config.Source{{ID: 1, Priority: 1}, {ID: 2, Priority: 0}}
+	prio := newBuffer(t, cfg)
+	save(t, prio, 1, 1)
+	save(t, prio, 2, 2)
+	if _, source, number, _ := prio.GetTest(); source != 2 || number != 2 {
+		t.Errorf("GetTest with priority classes = %d/%d, want 2/2", source, number)
+	}
+}
+
+func testDeleteTest(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(2, ""))
+
+	save(t, buf, 1, 1)
+	save(t, buf, 1, 2)
+
+	victim, _ := contains(list(t, buf), 1, 1)
+	if err := buf.DeleteTest(victim.Pos); err != nil {
+		t.Fatalf("DeleteTest(%d): %v", victim.Pos, err)
+	}
+	if got := available(t, buf); got != 1 {
+		t.Fatalf("available space after delete = %d, want 1", got)
+	}
+	if _, ok := contains(list(t, buf), 1, 1); ok {
+		t.Errorf("deleted test is still buffered")
+	}
+
+	if err := buf.DeleteTest(victim.Pos); err != nil {
+		t.Errorf("deleting an empty position: %v", err)
+	}
+
+	save(t, buf, 1, 3)
+	if got := available(t, buf); got != 0 {
+		t.Errorf("available space after refill = %d, want 0", got)
+	}
+	if pending := refusals(t, buf); len(pending) != 0 {
+		t.Errorf("refilling a freed position evicted %+v", pending)
+	}
+}
+
+func testRefusals(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(1, ""))
+
+	if pending := refusals(t, buf); len(pending) != 0 {
+		t.Fatalf("pending refusals of an empty trash = %+v", pending)
+	}
+
+	save(t, buf, 1, 1)
+	save(t, buf, 1, 2)
+	save(t, buf, 1, 3)
+
+	pending := refusals(t, buf)
+	if len(pending) != 2 || pending[0].TestNumber != 1 || pending[1].TestNumber != 2 {
+		t.Fatalf("pending refusals = %+v, want 1/1 and 1/2", pending)
+	}
+	if pending[0].ID == pending[1].ID {
+		t.Errorf("refusals share id %d", pending[0].ID)
+	}
+	if again := refusals(t, buf); len(again) != 2 {
+		t.Errorf("pending refusals changed before notification: %+v", again)
+	}
+
+	limited, err := buf.PendingRefusals(1)
+	if err != nil || len(limited) != 1 || limited[0].ID != pending[0].ID {
+		t.Errorf("PendingRefusals(1) = %+v, %v, want the oldest", limited, err)
+	}
+
+	if err := buf.MarkRefusalsNotified([]int64{pending[0].ID}); err != nil {
+		t.Fatalf("MarkRefusalsNotified: %v", err)
+	}
+	rest := refusals(t, buf)
+	if len(rest) != 1 || rest[0].ID != pending[1].ID {
+		t.Errorf("pending refusals after notification = %+v, want 1/2 only", rest)
+	}
+}
+
+func testResults(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(1, ""))
+
+	completed := test.Transition{State: test.StateCompleted, Detail: "result=true"}
+	first := test.Result{TestRequest: test.TestRequest{SourceID: 1, TestNumber: 1}, DeviceID: 4, Passed: true, Duration: 30 * time.Millisecond, Msg: "ok"}
+	second := test.Result{TestRequest: test.TestRequest{SourceID: 1, TestNumber: 2}, DeviceID: 5}
+	for _, r := range []test.Result{first, second} {
+		if err := buf.RecordResult(r, completed); err != nil {
+			t.Fatalf("RecordResult: %v", err)
+		}
+	}
+
+	if got, want := states(t, buf, 1, 1), []string{test.StateCompleted}; !equal(got, want) {
+		t.Errorf("lifecycle = %v, want %v", got, want)
+	}
+
+	pending, err := buf.PendingResults(10)
+	if err != nil {
+		t.Fatalf("PendingResults: %v", err)
+	}
+	if len(pending) != 2 || pending[0].ID == pending[1].ID {
+		t.Fatalf("pending results = %+v, want two with distinct ids", pending)
+	}
+	got := pending[0]
+	got.ID = 0
+	if got != first {
+		t.Errorf("oldest pending result = %+v, want %+v", got, first)
+	}
+
+	if err := buf.MarkResultsSent([]int64{pending[0].ID}); err != nil {
+		t.Fatalf("MarkResultsSent: %v", err)
+	}
+	rest, err := buf.PendingResults(10)
+	if err != nil || len(rest) != 1 || rest[0].ID != pending[1].ID {
+		t.Errorf("pending results after sending = %+v, %v, want 1/2 only", rest, err)
+	}
+}
+
+func testInFlightRetry(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(3, ""))
+
+	save(t, buf, 1, 1)
+	b, _ := contains(list(t, buf), 1, 1)
+	if !b.Ready || b.InFlight || b.Attempts != 0 {
+		t.Fatalf("new test = %+v, want ready with no attempts", b)
+	}
+
+	if err := buf.MarkInFlight(b.Pos); err != nil {
+		t.Fatalf("MarkInFlight: %v", err)
+	}
+	if err := buf.MarkInFlight(b.Pos); !errors.Is(err, test.ErrTestNotFound) {
+		t.Fatalf("second MarkInFlight = %v, want ErrTestNotFound", err)
+	}
+	if err := buf.MarkInFlight(b.Pos + 1); !errors.Is(err, test.ErrTestNotFound) {
+		t.Fatalf("MarkInFlight on an empty position = %v, want ErrTestNotFound", err)
+	}
+
+	b, _ = contains(list(t, buf), 1, 1)
+	if b.Ready || !b.InFlight {
+		t.Fatalf("in-flight test = %+v", b)
+	}
+	if pos, _, _, _ := buf.GetTest(); pos != -1 {
+		t.Errorf("GetTest returned in-flight position %d", pos)
+	}
+
+	dead, err := buf.RetryTest(b.Pos, time.Hour, 2, "device is gone")
+	if err != nil || dead {
+		t.Fatalf("first RetryTest = %v, %v, want not dead", dead, err)
+	}
+	b, _ = contains(list(t, buf), 1, 1)
+	if b.Ready || b.InFlight || b.Attempts != 1 {
+		t.Fatalf("test in backoff = %+v", b)
+	}
+	if _, err := buf.RetryTest(b.Pos, 0, 2, "again"); !errors.Is(err, test.ErrTestNotFound) {
+		t.Fatalf("RetryTest on a test that is not in flight = %v, want ErrTestNotFound", err)
+	}
+
+	if err := buf.MarkInFlight(b.Pos); err != nil {
+		t.Fatalf("MarkInFlight after backoff: %v", err)
+	}
+	dead, err = buf.RetryTest(b.Pos, 0, 2, "device is gone")
+	if err != nil || !dead {
+		t.Fatalf("last RetryTest = %v, %v, want dead", dead, err)
+	}
+	if got := available(t, buf); got != 3 {
+		t.Errorf("available space after dead letter = %d, want 3", got)
+	}
+}
+
+func testInFlightNotEvicted(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(2, ""))
+
+	save(t, buf, 1, 1)
+	save(t, buf, 2, 1)
+	b, _ := contains(list(t, buf), 1, 1)
+	if err := buf.MarkInFlight(b.Pos); err != nil {
+		t.Fatalf("MarkInFlight: %v", err)
+	}
+
+	save(t, buf, 3, 1)
+
+	buffered := list(t, buf)
+	if _, ok := contains(buffered, 1, 1); !ok {
+		t.Fatalf("in-flight test was evicted")
+	}
+	if _, ok := contains(buffered, 2, 1); ok {
+		t.Errorf("test 2/1 should have been evicted instead")
+	}
+}
+
+func states(t *testing.T, buf test.TestCycleBuffer, source, number uint) []string {
+	t.Helper()
+
+	transitions, err := buf.GetLifecycle(source, number)
+	if err != nil {
+		t.Fatalf("GetLifecycle(%d/%d): %v", source, number, err)
+	}
+
+	var got []string
+	for i, tr := range transitions {
+		if i > 0 && tr.At.Before(transitions[i-1].At) {
+			t.Errorf("transition %s of %d/%d happened before %s", tr.State, source, number, transitions[i-1].State)
+		}
+		got = append(got, tr.State)
+	}
+	return got
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testLifecycle(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(1, ""))
+
+	if got := states(t, buf, 1, 1); len(got) != 0 {
+		t.Fatalf("unknown test has lifecycle %v", got)
+	}
+
+	save(t, buf, 1, 1)
+
+	transitions, _ := buf.GetLifecycle(1, 1)
+	if len(transitions) != 2 || transitions[1].Pos == nil {
+		t.Fatalf("buffered transition = %+v, want a position", transitions)
+	}
+	pos := *transitions[1].Pos
+
+	if err := buf.MarkInFlight(pos); err != nil {
+		t.Fatalf("MarkInFlight: %v", err)
+	}
+	if _, err := buf.RetryTest(pos, 0, 3, "device is gone"); err != nil {
+		t.Fatalf("RetryTest: %v", err)
+	}
+	if err := buf.MarkInFlight(pos); err != nil {
+		t.Fatalf("MarkInFlight: %v", err)
+	}
+	if err := buf.DispatchTest(pos, 7); err != nil {
+		t.Fatalf("DispatchTest: %v", err)
+	}
+	if err := buf.DispatchTest(pos, 7); !errors.Is(err, test.ErrTestNotFound) {
+		t.Fatalf("second DispatchTest = %v, want ErrTestNotFound", err)
+	}
+	if got := available(t, buf); got != 1 {
+		t.Errorf("available space after dispatch = %d, want 1", got)
+	}
+
+	want := []string{test.StateReceived, test.StateBuffered, test.StateSendFailed, test.StateDispatched}
+	if got := states(t, buf, 1, 1); !equal(got, want) {
+		t.Errorf("lifecycle = %v, want %v", got, want)
+	}
+	transitions, _ = buf.GetLifecycle(1, 1)
+	if d := transitions[3].DeviceID; d == nil || *d != 7 {
+		t.Errorf("dispatched transition device = %v, want 7", d)
+	}
+
+	save(t, buf, 1, 2)
+	save(t, buf, 1, 3)
+	if got, want := states(t, buf, 1, 2), []string{test.StateReceived, test.StateBuffered, test.StateEvicted}; !equal(got, want) {
+		t.Errorf("evicted lifecycle = %v, want %v", got, want)
+	}
+
+	rejecting := newBuffer(t, newConfig(1, "reject"))
+	save(t, rejecting, 2, 1)
+	save(t, rejecting, 2, 2)
+	if got, want := states(t, rejecting, 2, 2), []string{test.StateReceived, test.StateRejected}; !equal(got, want) {
+		t.Errorf("rejected lifecycle = %v, want %v", got, want)
+	}
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	states []string
+}
+
+func (r *recorder) Observe(_, _ uint, tr test.Transition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tr.At.IsZero() {
+		tr.State += " without time"
+	}
+	r.states = append(r.states, tr.State)
+}
+
+func testObservers(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(1, ""))
+	rec := &recorder{}
+	buf.Subscribe(rec)
+
+	save(t, buf, 1, 1)
+	save(t, buf, 1, 2)
+
+	pos := list(t, buf)[0].Pos
+	if err := buf.MarkInFlight(pos); err != nil {
+		t.Fatalf("MarkInFlight: %v", err)
+	}
+	if err := buf.DispatchTest(pos, 3); err != nil {
+		t.Fatalf("DispatchTest: %v", err)
+	}
+
+	want := []string{
+		test.StateReceived, test.StateBuffered,
+		test.StateReceived, test.StateEvicted, test.StateBuffered,
+		test.StateDispatched,
+	}
+	if !equal(rec.states, want) {
+		t.Errorf("observed %v, want %v", rec.states, want)
+	}
+}
+
+func testSaveTests(t *testing.T, newBuffer Factory) {
+	buf := newBuffer(t, newConfig(2, ""))
+
+	reqs := []test.TestRequest{{SourceID: 1, TestNumber: 1}, {SourceID: 1, TestNumber: 2}, {SourceID: 1, TestNumber: 3}}
+	results, err := buf.SaveTests(reqs)
+	if err != nil {
+		t.Fatalf("SaveTests: %v", err)
+	}
+	if len(results) != len(reqs) {
+		t.Fatalf("SaveTests returned %d results, want %d", len(results), len(reqs))
+	}
+	if results[0].Rejected || results[0].Evicted != nil || results[1].Rejected || results[1].Evicted != nil {
+		t.Errorf("results before overflow = %+v, want plain saves", results[:2])
+	}
+	if results[0].Pos == results[1].Pos {
+		t.Errorf("both tests saved at position %d", results[0].Pos)
+	}
+	if ev := results[2].Evicted; ev == nil || *ev != reqs[0] {
+		t.Errorf("overflow evicted %v, want 1/1", ev)
+	}
+	if results[2].Pos != results[0].Pos {
+		t.Errorf("incoming test took position %d, want freed position %d", results[2].Pos, results[0].Pos)
+	}
+
+	buffered := list(t, buf)
+	if len(buffered) != 2 {
+		t.Fatalf("buffer holds %d tests, want 2", len(buffered))
+	}
+	if b, ok := contains(buffered, 1, 3); !ok || b.Pos != results[2].Pos {
+		t.Errorf("1/3 buffered = %+v, %v, want at position %d", b, ok, results[2].Pos)
+	}
+	if got, want := states(t, buf, 1, 1), []string{test.StateReceived, test.StateBuffered, test.StateEvicted}; !equal(got, want) {
+		t.Errorf("evicted lifecycle = %v, want %v", got, want)
+	}
+
+	rejecting := newBuffer(t, newConfig(1, "reject"))
+	results, err = rejecting.SaveTests([]test.TestRequest{{SourceID: 2, TestNumber: 1}, {SourceID: 2, TestNumber: 2}})
+	if err != nil {
+		t.Fatalf("SaveTests: %v", err)
+	}
+	if len(results) != 2 || results[0].Rejected || !results[1].Rejected {
+		t.Errorf("results = %+v, want the second test rejected", results)
+	}
+	if got, want := states(t, rejecting, 2, 2), []string{test.StateReceived, test.StateRejected}; !equal(got, want) {
+		t.Errorf("rejected lifecycle = %v, want %v", got, want)
+	}
+}
+
+func testConcurrent(t *testing.T, newBuffer Factory) {
+	const (
+		maxSize = 8
+		sources = 4
+		perSrc  = 25
+	)
+	buf := newBuffer(t, newConfig(maxSize, ""))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, sources*perSrc*2)
+
+	for s := uint(1); s <= sources; s++ {
+		wg.Add(1)
+		go func(source uint) {
+			defer wg.Done()
+			for n := uint(1); n <= perSrc; n++ {
+				if err := buf.SaveTest(&test.TestRequest{SourceID: source, TestNumber: n}); err != nil {
+					errs <- err
+				}
+			}
+		}(s)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < sources*perSrc/2; i++ {
+			pos, _, _, err := buf.GetTest()
+			if err != nil {
+				errs <- err
+				continue
+			}
+			if pos == -1 {
+				continue
+			}
+			if err := buf.DeleteTest(pos); err != nil {
+				errs <- err
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent access: %v", err)
+	}
+
+	buffered := list(t, buf)
+	if len(buffered) > maxSize {
+		t.Fatalf("buffer holds %d tests, more than %d", len(buffered), maxSize)
+	}
+	if got, want := available(t, buf), maxSize-int64(len(buffered)); got != want {
+		t.Errorf("available space = %d, want %d", got, want)
+	}
+
+	positions := make(map[int64]bool)
+	for _, b := range buffered {
+		if positions[b.Pos] {
+			t.Errorf("position %d is used twice", b.Pos)
+		}
+		positions[b.Pos] = true
+	}
+}